@@ -0,0 +1,122 @@
+// Package agent implements a helper agent running in a container, and its
+// protocol.  The agent serves file operations on the container over its stdin
+// and stdout, so kubectl-mount can access files without any commands in the
+// container.
+package agent
+
+// Operations of the agent
+const (
+	OpStat     = "stat"
+	OpReadDir  = "readdir"
+	OpReadlink = "readlink"
+	OpRead     = "read"
+	OpWrite    = "write"
+	OpMkdir    = "mkdir"
+	OpRemove   = "remove"
+	OpRmdir    = "rmdir"
+	OpRename   = "rename"
+	OpSymlink  = "symlink"
+	OpLink     = "link"
+	OpChmod    = "chmod"
+	OpChown    = "chown"
+	OpTruncate = "truncate"
+	OpChtimes  = "chtimes"
+	OpXattrs   = "xattrs"
+	OpStatfs   = "statfs"
+
+	// OpWatch watches changes of files in the directory recursively.  The
+	// agent sends a response with the path of the changed file in Target
+	// for each change, and a response with Errno when it stops watching.
+	OpWatch = "watch"
+)
+
+// MaxReadSize is the maximum size of data in a response of OpRead.
+const MaxReadSize = 1 << 20
+
+// Request is a request to the agent.  Requests and responses are encoded by
+// encoding/gob, which frames each message with its length.
+type Request struct {
+	ID uint64
+	Op string
+
+	// Path is a target of the operation.  Path2 is a destination of OpRename
+	// and OpLink, or a target of the symlink on OpSymlink.
+	Path  string
+	Path2 string
+
+	Offset int64
+	Size   int64
+	Mode   uint32
+	Uid    int
+	Gid    int
+	Atime  Timespec
+	Mtime  Timespec
+	Data   []byte
+}
+
+// Response is a response from the agent for the request with the same ID.
+type Response struct {
+	ID uint64
+
+	// Errno is an errno of the failed operation, or zero on success.
+	Errno int
+	Error string
+
+	Stat    *Stat
+	Entries []Dirent
+
+	// Target is a target of the symlink on OpReadlink, or a path of the
+	// changed file on OpWatch.
+	Target string
+	Data   []byte
+
+	// Xattrs is extended attributes of the file on OpXattrs.
+	Xattrs map[string][]byte
+
+	// Statfs is statistics of the filesystem containing the file on
+	// OpStatfs.
+	Statfs *Statfs
+}
+
+// Timespec is a time in seconds and nanoseconds since the epoch.  A zero
+// Timespec means that the time is not specified.
+type Timespec struct {
+	Sec  int64
+	Nsec int64
+}
+
+// Stat is attributes of a file on the container.
+type Stat struct {
+	Dev     uint64
+	Ino     uint64
+	Nlink   uint64
+	Mode    uint32
+	Uid     uint32
+	Gid     uint32
+	Rdev    uint64
+	Size    int64
+	Blksize int64
+	Blocks  int64
+	Atim    Timespec
+	Mtim    Timespec
+	Ctim    Timespec
+}
+
+// Statfs is statistics of a filesystem on the container.  Numbers of blocks
+// are in units of Frsize.
+type Statfs struct {
+	Bsize   int64
+	Frsize  int64
+	Blocks  uint64
+	Bfree   uint64
+	Bavail  uint64
+	Files   uint64
+	Ffree   uint64
+	Namelen int64
+}
+
+// Dirent is an entry in the directory with its attributes.
+type Dirent struct {
+	Name string
+	Stat Stat
+}
@@ -0,0 +1,256 @@
+package agent
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Serve reads requests from r, and writes responses to w until r is closed.
+// Requests are processed concurrently, and responses are written in the
+// order of completion.
+func Serve(r io.Reader, w io.Writer) error {
+	dec := gob.NewDecoder(r)
+	enc := gob.NewEncoder(w)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var encErr error
+	send := func(res *Response) error {
+		mu.Lock()
+		defer mu.Unlock()
+		err := enc.Encode(res)
+		if err != nil {
+			encErr = err
+		}
+		return err
+	}
+	for {
+		var req Request
+		err := dec.Decode(&req)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		// Watching continues until the agent exits, so it is not waited
+		if req.Op == OpWatch {
+			go func() {
+				err := watch(req.Path, func(name string) error {
+					return send(&Response{ID: req.ID, Target: name})
+				})
+				send(errorResponse(req.ID, err))
+			}()
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			send(handle(&req))
+		}()
+	}
+	wg.Wait()
+	return encErr
+}
+
+func handle(req *Request) *Response {
+	res, err := dispatch(req)
+	if err != nil {
+		return errorResponse(req.ID, err)
+	}
+	if res == nil {
+		res = &Response{}
+	}
+	res.ID = req.ID
+	return res
+}
+
+// errorResponse returns a response of the failed request.  The errno is EIO
+// if err is not syscall.Errno.
+func errorResponse(id uint64, err error) *Response {
+	res := &Response{ID: id, Errno: int(syscall.EIO)}
+	if err == nil {
+		return res
+	}
+	res.Error = err.Error()
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		res.Errno = int(errno)
+	}
+	return res
+}
+
+func dispatch(req *Request) (*Response, error) {
+	switch req.Op {
+	case OpStat:
+		st, err := lstat(req.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &Response{Stat: st}, nil
+	case OpReadDir:
+		entries, err := readDir(req.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &Response{Entries: entries}, nil
+	case OpReadlink:
+		target, err := os.Readlink(req.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &Response{Target: target}, nil
+	case OpRead:
+		data, err := readAt(req.Path, req.Offset, req.Size)
+		if err != nil {
+			return nil, err
+		}
+		return &Response{Data: data}, nil
+	case OpWrite:
+		return nil, writeFile(req.Path, req.Data, os.FileMode(req.Mode))
+	case OpMkdir:
+		return nil, mkdir(req.Path, req.Mode)
+	case OpRemove:
+		return nil, syscall.Unlink(req.Path)
+	case OpRmdir:
+		return nil, syscall.Rmdir(req.Path)
+	case OpRename:
+		return nil, os.Rename(req.Path, req.Path2)
+	case OpSymlink:
+		return nil, os.Symlink(req.Path2, req.Path)
+	case OpLink:
+		return nil, os.Link(req.Path, req.Path2)
+	case OpChmod:
+		return nil, syscall.Chmod(req.Path, req.Mode)
+	case OpChown:
+		return nil, os.Lchown(req.Path, req.Uid, req.Gid)
+	case OpTruncate:
+		return nil, os.Truncate(req.Path, req.Size)
+	case OpChtimes:
+		return nil, chtimes(req.Path, req.Atime, req.Mtime)
+	case OpXattrs:
+		attrs, err := xattrs(req.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &Response{Xattrs: attrs}, nil
+	case OpStatfs:
+		st, err := statfs(req.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &Response{Statfs: st}, nil
+	}
+	return nil, fmt.Errorf("unknown operation: %q", req.Op)
+}
+
+func lstat(name string) (*Stat, error) {
+	inf, err := os.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	return toStat(inf), nil
+}
+
+func readDir(name string) ([]Dirent, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	infs, err := f.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Dirent, len(infs))
+	for i, inf := range infs {
+		entries[i] = Dirent{Name: inf.Name(), Stat: *toStat(inf)}
+	}
+	return entries, nil
+}
+
+func readAt(name string, off, size int64) ([]byte, error) {
+	if size > MaxReadSize {
+		size = MaxReadSize
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, size)
+	n, err := f.ReadAt(buf, off)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// writeFile replaces the file with data.  The data is written to a temporary
+// file in the same directory, and it is renamed to the file.
+func writeFile(name string, data []byte, perm os.FileMode) error {
+	if dst, err := filepath.EvalSymlinks(name); err == nil {
+		name = dst
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".kubectl-mount.")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if inf, err := os.Stat(name); err == nil {
+		st := toStat(inf)
+		perm = inf.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+		_ = os.Chown(f.Name(), int(st.Uid), int(st.Gid))
+	}
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// mkdir creates the directory with the mode in the bits of st_mode.  The
+// setuid, setgid and sticky bits are set by chmod like mkdir -m, since
+// mkdir(2) may ignore them.
+func mkdir(name string, mode uint32) error {
+	if err := syscall.Mkdir(name, mode&0777); err != nil {
+		return err
+	}
+	if mode&07000 != 0 {
+		return syscall.Chmod(name, mode&07777)
+	}
+	return nil
+}
+
+func chtimes(name string, atime, mtime Timespec) error {
+	inf, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	st := toStat(inf)
+	if atime == (Timespec{}) {
+		atime = st.Atim
+	}
+	if mtime == (Timespec{}) {
+		mtime = st.Mtim
+	}
+	return os.Chtimes(name, time.Unix(atime.Sec, atime.Nsec), time.Unix(mtime.Sec, mtime.Nsec))
+}
@@ -0,0 +1,207 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"testing"
+)
+
+// testClient sends requests to Serve over pipes, and receives responses in
+// the order of the requests.
+type testClient struct {
+	t    *testing.T
+	enc  *gob.Encoder
+	dec  *gob.Decoder
+	w    io.Closer
+	done chan error
+	id   uint64
+}
+
+func newTestClient(t *testing.T) *testClient {
+	inr, inw := io.Pipe()
+	outr, outw := io.Pipe()
+	c := &testClient{
+		t:    t,
+		enc:  gob.NewEncoder(inw),
+		dec:  gob.NewDecoder(outr),
+		w:    inw,
+		done: make(chan error, 1),
+	}
+	go func() {
+		err := Serve(inr, outw)
+		outw.Close()
+		c.done <- err
+	}()
+	return c
+}
+
+// call sends the request and waits the response.  Requests are sent one by
+// one, so responses are not reordered.
+func (c *testClient) call(req Request) *Response {
+	c.t.Helper()
+	c.id++
+	req.ID = c.id
+	if err := c.enc.Encode(&req); err != nil {
+		c.t.Fatal(err)
+	}
+	var res Response
+	if err := c.dec.Decode(&res); err != nil {
+		c.t.Fatal(err)
+	}
+	if res.ID != req.ID {
+		c.t.Fatalf("%s: ID = %d, want %d", req.Op, res.ID, req.ID)
+	}
+	return &res
+}
+
+// close closes the stdin of the agent, and waits Serve to return.
+func (c *testClient) close() {
+	c.t.Helper()
+	c.w.Close()
+	if err := <-c.done; err != nil {
+		c.t.Errorf("Serve: %v", err)
+	}
+}
+
+func TestServe(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello world"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t)
+	defer c.close()
+
+	res := c.call(Request{Op: OpStat, Path: filepath.Join(dir, "hello.txt")})
+	if res.Errno != 0 || res.Stat == nil {
+		t.Fatalf("stat: %+v", res)
+	}
+	if res.Stat.Size != 11 || res.Stat.Mode != syscall.S_IFREG|0640 {
+		t.Errorf("stat: unexpected stat %+v", res.Stat)
+	}
+
+	res = c.call(Request{Op: OpRead, Path: filepath.Join(dir, "hello.txt"), Offset: 6, Size: 100})
+	if res.Errno != 0 || string(res.Data) != "world" {
+		t.Errorf("read: %+v", res)
+	}
+
+	res = c.call(Request{Op: OpWrite, Path: filepath.Join(dir, "new.txt"), Data: []byte("new"), Mode: 0600})
+	if res.Errno != 0 {
+		t.Errorf("write: %+v", res)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "new.txt")); err != nil || string(data) != "new" {
+		t.Errorf("write: content = %q, %v", data, err)
+	}
+
+	for _, req := range []Request{
+		{Op: OpMkdir, Path: filepath.Join(dir, "sub"), Mode: 0755},
+		{Op: OpRename, Path: filepath.Join(dir, "new.txt"), Path2: filepath.Join(dir, "sub/renamed.txt")},
+		{Op: OpSymlink, Path: filepath.Join(dir, "link"), Path2: "hello.txt"},
+		{Op: OpChtimes, Path: filepath.Join(dir, "hello.txt"), Mtime: Timespec{Sec: 1617280496, Nsec: 789}},
+	} {
+		if res := c.call(req); res.Errno != 0 {
+			t.Errorf("%s: %+v", req.Op, res)
+		}
+	}
+
+	// Replacing a file keeps the special bits of the mode
+	setuid := filepath.Join(dir, "sub/setuid")
+	if err := os.WriteFile(setuid, nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(setuid, 0755|os.ModeSetuid); err != nil {
+		t.Fatal(err)
+	}
+	if res := c.call(Request{Op: OpWrite, Path: setuid, Data: []byte("new"), Mode: 0644}); res.Errno != 0 {
+		t.Errorf("write: %+v", res)
+	}
+	if inf, err := os.Stat(setuid); err != nil || inf.Mode() != 0755|os.ModeSetuid {
+		t.Errorf("write: stat = %v, %v, want mode %v", inf, err, 0755|os.ModeSetuid)
+	}
+
+	res = c.call(Request{Op: OpReadDir, Path: dir})
+	if res.Errno != 0 {
+		t.Fatalf("readdir: %+v", res)
+	}
+	var names []string
+	for _, e := range res.Entries {
+		names = append(names, e.Name)
+	}
+	sort.Strings(names)
+	if want := []string{"hello.txt", "link", "sub"}; len(names) != len(want) || names[0] != want[0] || names[1] != want[1] || names[2] != want[2] {
+		t.Errorf("readdir: names = %q, want %q", names, want)
+	}
+
+	res = c.call(Request{Op: OpReadlink, Path: filepath.Join(dir, "link")})
+	if res.Errno != 0 || res.Target != "hello.txt" {
+		t.Errorf("readlink: %+v", res)
+	}
+	res = c.call(Request{Op: OpStat, Path: filepath.Join(dir, "hello.txt")})
+	if res.Errno != 0 || res.Stat.Mtim != (Timespec{Sec: 1617280496, Nsec: 789}) {
+		t.Errorf("chtimes: mtime = %+v", res.Stat)
+	}
+}
+
+func TestServeErrors(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestClient(t)
+	defer c.close()
+
+	tests := []struct {
+		req   Request
+		errno syscall.Errno
+	}{
+		{Request{Op: OpStat, Path: filepath.Join(dir, "missing")}, syscall.ENOENT},
+		{Request{Op: OpRead, Path: filepath.Join(dir, "missing")}, syscall.ENOENT},
+		{Request{Op: OpRmdir, Path: dir + "/missing"}, syscall.ENOENT},
+		{Request{Op: OpMkdir, Path: dir}, syscall.EEXIST},
+		{Request{Op: "unknown"}, syscall.EIO},
+	}
+	for _, tt := range tests {
+		res := c.call(tt.req)
+		if syscall.Errno(res.Errno) != tt.errno {
+			t.Errorf("%s: errno = %v, want %v", tt.req.Op, syscall.Errno(res.Errno), tt.errno)
+		}
+		if res.Error == "" {
+			t.Errorf("%s: empty error message", tt.req.Op)
+		}
+	}
+}
+
+func TestServeConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("x"), MaxReadSize+10)
+	if err := os.WriteFile(filepath.Join(dir, "large"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t)
+	defer c.close()
+
+	// Responses are matched by the IDs, since they are written in the
+	// order of completion
+	const n = 10
+	for i := 0; i < n; i++ {
+		req := Request{ID: uint64(i + 1), Op: OpRead, Path: filepath.Join(dir, "large"), Size: MaxReadSize * 2}
+		if err := c.enc.Encode(&req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen := map[uint64]bool{}
+	for i := 0; i < n; i++ {
+		var res Response
+		if err := c.dec.Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Errno != 0 || len(res.Data) != MaxReadSize {
+			t.Errorf("read %d: errno %d, %d bytes, want %d bytes", res.ID, res.Errno, len(res.Data), MaxReadSize)
+		}
+		seen[res.ID] = true
+	}
+	if len(seen) != n {
+		t.Errorf("responses for %d requests, want %d", len(seen), n)
+	}
+}
@@ -0,0 +1,28 @@
+package agent
+
+import (
+	"os"
+	"syscall"
+)
+
+func toStat(inf os.FileInfo) *Stat {
+	sys, ok := inf.Sys().(*syscall.Stat_t)
+	if !ok {
+		return &Stat{Size: inf.Size()}
+	}
+	return &Stat{
+		Dev:     sys.Dev,
+		Ino:     sys.Ino,
+		Nlink:   uint64(sys.Nlink),
+		Mode:    sys.Mode,
+		Uid:     sys.Uid,
+		Gid:     sys.Gid,
+		Rdev:    sys.Rdev,
+		Size:    sys.Size,
+		Blksize: int64(sys.Blksize),
+		Blocks:  sys.Blocks,
+		Atim:    Timespec{Sec: int64(sys.Atim.Sec), Nsec: int64(sys.Atim.Nsec)},
+		Mtim:    Timespec{Sec: int64(sys.Mtim.Sec), Nsec: int64(sys.Mtim.Nsec)},
+		Ctim:    Timespec{Sec: int64(sys.Ctim.Sec), Nsec: int64(sys.Ctim.Nsec)},
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package agent
+
+import (
+	"os"
+)
+
+// toStat returns attributes available on the all platforms.  The agent is
+// expected to run on linux containers.
+func toStat(inf os.FileInfo) *Stat {
+	return &Stat{
+		Mode: uint32(inf.Mode().Perm()),
+		Size: inf.Size(),
+		Mtim: Timespec{Sec: inf.ModTime().Unix(), Nsec: int64(inf.ModTime().Nanosecond())},
+	}
+}
@@ -0,0 +1,22 @@
+package agent
+
+import (
+	"syscall"
+)
+
+func statfs(p string) (*Statfs, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(p, &st); err != nil {
+		return nil, err
+	}
+	return &Statfs{
+		Bsize:   int64(st.Bsize),
+		Frsize:  int64(st.Frsize),
+		Blocks:  st.Blocks,
+		Bfree:   st.Bfree,
+		Bavail:  st.Bavail,
+		Files:   st.Files,
+		Ffree:   st.Ffree,
+		Namelen: int64(st.Namelen),
+	}, nil
+}
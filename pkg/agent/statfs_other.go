@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package agent
+
+import (
+	"syscall"
+)
+
+// statfs is not supported since the agent is expected to run on linux
+// containers.
+func statfs(p string) (*Statfs, error) {
+	return nil, syscall.ENOTSUP
+}
@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"bytes"
+	"io/fs"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const watchMask = syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB |
+	syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// watch watches changes of files in the directory and its subdirectories
+// by inotify, and calls changed with paths of the changed files.  It returns
+// when changed returns an error.  Directories created later are also
+// watched.
+func watch(root string, changed func(name string) error) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	dirs := map[int32]string{}
+	add := func(dir string) {
+		filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return nil
+			}
+			if wd, err := syscall.InotifyAddWatch(fd, p, watchMask); err == nil {
+				dirs[int32(wd)] = p
+			}
+			return nil
+		})
+	}
+	add(root)
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := syscall.Read(fd, buf)
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			return err
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameBytes := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+			off += syscall.SizeofInotifyEvent + int(ev.Len)
+
+			dir, ok := dirs[ev.Wd]
+			if !ok {
+				continue
+			}
+			if ev.Mask&syscall.IN_IGNORED != 0 {
+				delete(dirs, ev.Wd)
+				continue
+			}
+			p := dir
+			if name := bytes.TrimRight(nameBytes, "\x00"); len(name) > 0 {
+				p = filepath.Join(dir, string(name))
+			}
+			if ev.Mask&syscall.IN_ISDIR != 0 && ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				add(p)
+			}
+			if err := changed(p); err != nil {
+				return err
+			}
+		}
+	}
+}
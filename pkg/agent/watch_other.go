@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package agent
+
+import "syscall"
+
+// watch is not supported without inotify.
+func watch(root string, changed func(name string) error) error {
+	return syscall.ENOSYS
+}
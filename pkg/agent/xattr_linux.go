@@ -0,0 +1,58 @@
+package agent
+
+import (
+	"bytes"
+	"syscall"
+)
+
+// xattrs returns extended attributes of the file.  Attributes which are
+// removed while reading them are skipped.
+func xattrs(p string) (map[string][]byte, error) {
+	names, err := readXattr(func(dest []byte) (int, error) {
+		return syscall.Listxattr(p, dest)
+	})
+	if err != nil {
+		return nil, err
+	}
+	attrs := map[string][]byte{}
+	for _, name := range bytes.Split(names, []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := readXattr(func(dest []byte) (int, error) {
+			return syscall.Getxattr(p, string(name), dest)
+		})
+		if err == syscall.ENODATA {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		attrs[string(name)] = value
+	}
+	return attrs, nil
+}
+
+// readXattr reads a value by calling read with a buffer of the size
+// returned by calling it with an empty buffer.  It retries if the value
+// grows between the calls.
+func readXattr(read func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := read(nil)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return []byte{}, nil
+		}
+		buf := make([]byte, size)
+		n, err := read(buf)
+		if err == syscall.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
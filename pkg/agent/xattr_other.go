@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package agent
+
+import (
+	"syscall"
+)
+
+// xattrs is not supported since the agent is expected to run on linux
+// containers.
+func xattrs(p string) (map[string][]byte, error) {
+	return nil, syscall.ENOTSUP
+}
@@ -0,0 +1,485 @@
+package cmd
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ueokande/kubectl-mount/pkg/agent"
+)
+
+// errAgentClosed is returned for requests after the agent is stopped.
+var errAgentClosed = errors.New("agent is not running")
+
+// AgentClient sends requests to the helper agent running in the container
+// over a single long-lived stream.
+type AgentClient struct {
+	mu      sync.Mutex
+	wmu     sync.Mutex
+	enc     *gob.Encoder
+	w       io.Closer
+	nextID  uint64
+	pending map[uint64]chan *agent.Response
+	watches map[uint64]bool
+	err     error
+}
+
+// installAgentScript writes the stdin to a temporary file, and renames it
+// to $1.  The agent of another mount may be running from $1, which cannot be
+// overwritten with ETXTBSY.
+const installAgentScript = `tmp="$1.$$"
+if cat >"$tmp" && chmod +x "$tmp" && mv -f "$tmp" "$1"; then
+	exit 0
+fi
+rm -f "$tmp"
+exit 1
+`
+
+// InstallAgent copies the agent binary from the local path to the remote
+// path in the container.
+func InstallAgent(ctx context.Context, e Executor, local, remote string) error {
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = e.RunWrite(ctx, []string{"sh", "-c", installAgentScript, "sh", remote}, f)
+	if err != nil {
+		return fmt.Errorf("unable to install the agent to %s: %w", remote, err)
+	}
+	return nil
+}
+
+// StartAgent runs the agent by the command in the container, and returns a
+// client connected to the agent.
+func StartAgent(ctx context.Context, e Executor, command []string) *AgentClient {
+	inr, inw := io.Pipe()
+	outr, outw := io.Pipe()
+	c := &AgentClient{
+		enc:     gob.NewEncoder(inw),
+		w:       inw,
+		pending: map[uint64]chan *agent.Response{},
+		watches: map[uint64]bool{},
+	}
+
+	go func() {
+		err := e.RunStream(ctx, command, inr, outw)
+		if err == nil {
+			err = errAgentClosed
+		}
+		outw.CloseWithError(err)
+	}()
+	go c.receive(outr)
+
+	return c
+}
+
+func (c *AgentClient) receive(r io.Reader) {
+	dec := gob.NewDecoder(r)
+	for {
+		var res agent.Response
+		if err := dec.Decode(&res); err != nil {
+			c.abort(err)
+			return
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[res.ID]
+		watching := c.watches[res.ID]
+		if !watching || res.Errno != 0 {
+			delete(c.pending, res.ID)
+			delete(c.watches, res.ID)
+		}
+		c.mu.Unlock()
+		if !ok {
+			continue
+		}
+		if !watching {
+			ch <- &res
+			continue
+		}
+
+		// Changes are dropped rather than blocking other responses if the
+		// watcher is slow.  The channel is closed when watching stops.
+		select {
+		case ch <- &res:
+		default:
+		}
+		if res.Errno != 0 {
+			close(ch)
+		}
+	}
+}
+
+// abort fails all pending requests and the following requests with err.
+func (c *AgentClient) abort(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+		delete(c.watches, id)
+	}
+}
+
+// send sends a request to the agent, and returns a channel receiving
+// responses of the request.
+func (c *AgentClient) send(req *agent.Request, ch chan *agent.Response, watching bool) error {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.nextID++
+	req.ID = c.nextID
+	c.pending[req.ID] = ch
+	c.watches[req.ID] = watching
+	c.mu.Unlock()
+
+	c.wmu.Lock()
+	err := c.enc.Encode(req)
+	c.wmu.Unlock()
+	if err != nil {
+		c.abort(err)
+		return err
+	}
+	return nil
+}
+
+// Call sends a request to the agent, and waits for the response.  The error
+// of the failed operation is returned as syscall.Errno.
+func (c *AgentClient) Call(req *agent.Request) (*agent.Response, error) {
+	ch := make(chan *agent.Response, 1)
+	if err := c.send(req, ch, false); err != nil {
+		return nil, err
+	}
+
+	res, ok := <-ch
+	if !ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return nil, c.err
+	}
+	if res.Errno != 0 {
+		return nil, syscall.Errno(res.Errno)
+	}
+	return res, nil
+}
+
+// Watch watches changes of files in the directory on the container, and
+// calls changed with paths of the changed files until the context is done or
+// the agent stops watching.
+func (c *AgentClient) Watch(ctx context.Context, dir string, changed func(name string)) error {
+	ch := make(chan *agent.Response, 1024)
+	req := &agent.Request{Op: agent.OpWatch, Path: dir}
+	if err := c.send(req, ch, true); err != nil {
+		return err
+	}
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, req.ID)
+		delete(c.watches, req.ID)
+		c.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case res, ok := <-ch:
+			if !ok {
+				c.mu.Lock()
+				defer c.mu.Unlock()
+				if c.err == nil {
+					return errors.New("agent stopped watching")
+				}
+				return c.err
+			}
+			if res.Errno != 0 {
+				return syscall.Errno(res.Errno)
+			}
+			changed(res.Target)
+		}
+	}
+}
+
+// Close stops the agent.
+func (c *AgentClient) Close() error {
+	c.abort(errAgentClosed)
+	return c.w.Close()
+}
+
+// AgentFS is a filesystem on the container served by the helper agent.
+type AgentFS struct {
+	Client *AgentClient
+	Pwd    string
+}
+
+func (f *AgentFS) call(op, name string, req agent.Request) (*agent.Response, error) {
+	if !validPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	req.Op = op
+	req.Path = path.Join(f.Pwd, name)
+	res, err := f.Client.Call(&req)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: req.Path, Err: err}
+	}
+	return res, nil
+}
+
+func (f *AgentFS) Open(name string) (fs.File, error) {
+	_, err := f.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return &AgentFile{name: name, fs: f}, nil
+}
+
+func (f *AgentFS) Stat(name string) (fs.FileInfo, error) {
+	res, err := f.call(agent.OpStat, name, agent.Request{})
+	if err != nil {
+		return nil, err
+	}
+	return toPodFileInfo(path.Base(path.Join(f.Pwd, name)), res.Stat), nil
+}
+
+func (f *AgentFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	res, err := f.call(agent.OpReadDir, name, agent.Request{})
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]fs.DirEntry, len(res.Entries))
+	for i, e := range res.Entries {
+		inf := toPodFileInfo(e.Name, &e.Stat)
+		entries[i] = &PodDirEntry{
+			name: inf.name,
+			mode: inf.mode,
+			info: inf,
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (f *AgentFS) Readlink(name string) (string, error) {
+	res, err := f.call(agent.OpReadlink, name, agent.Request{})
+	if err != nil {
+		return "", err
+	}
+	return res.Target, nil
+}
+
+func (f *AgentFS) Xattrs(name string) (map[string][]byte, error) {
+	res, err := f.call(agent.OpXattrs, name, agent.Request{})
+	if err != nil {
+		return nil, err
+	}
+	return res.Xattrs, nil
+}
+
+func (f *AgentFS) Statfs(name string) (*LinuxStatfs_t, error) {
+	res, err := f.call(agent.OpStatfs, name, agent.Request{})
+	if err != nil {
+		return nil, err
+	}
+	st := LinuxStatfs_t(*res.Statfs)
+	return &st, nil
+}
+
+func (f *AgentFS) Watch(ctx context.Context, changed func(name string)) error {
+	return f.Client.Watch(ctx, f.Pwd, func(p string) {
+		if name, ok := relativePath(f.Pwd, p); ok {
+			changed(name)
+		}
+	})
+}
+
+func (f *AgentFS) Sub(dir string) (fs.FS, error) {
+	if !validPath(dir) {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: fs.ErrInvalid}
+	}
+	return &AgentFS{
+		Client: f.Client,
+		Pwd:    path.Join(f.Pwd, dir),
+	}, nil
+}
+
+func (f *AgentFS) WriteFile(name string, data io.Reader, perm fs.FileMode) error {
+	buf, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	_, err = f.call(agent.OpWrite, name, agent.Request{Data: buf, Mode: uint32(perm.Perm())})
+	return err
+}
+
+func (f *AgentFS) Mkdir(name string, perm fs.FileMode) error {
+	_, err := f.call(agent.OpMkdir, name, agent.Request{Mode: toRawMode(perm)})
+	return err
+}
+
+func (f *AgentFS) Remove(name string) error {
+	_, err := f.call(agent.OpRemove, name, agent.Request{})
+	return err
+}
+
+func (f *AgentFS) RemoveDir(name string) error {
+	_, err := f.call(agent.OpRmdir, name, agent.Request{})
+	return err
+}
+
+func (f *AgentFS) Rename(oldname, newname string) error {
+	_, err := f.call(agent.OpRename, oldname, agent.Request{Path2: path.Join(f.Pwd, newname)})
+	return err
+}
+
+func (f *AgentFS) Symlink(target, name string) error {
+	_, err := f.call(agent.OpSymlink, name, agent.Request{Path2: target})
+	return err
+}
+
+func (f *AgentFS) Link(oldname, newname string) error {
+	_, err := f.call(agent.OpLink, oldname, agent.Request{Path2: path.Join(f.Pwd, newname)})
+	return err
+}
+
+func (f *AgentFS) Chmod(name string, mode fs.FileMode) error {
+	_, err := f.call(agent.OpChmod, name, agent.Request{Mode: toRawMode(mode)})
+	return err
+}
+
+func (f *AgentFS) Chown(name string, uid, gid int) error {
+	_, err := f.call(agent.OpChown, name, agent.Request{Uid: uid, Gid: gid})
+	return err
+}
+
+func (f *AgentFS) Truncate(name string, size int64) error {
+	_, err := f.call(agent.OpTruncate, name, agent.Request{Size: size})
+	return err
+}
+
+func (f *AgentFS) Chtimes(name string, atime, mtime time.Time) error {
+	_, err := f.call(agent.OpChtimes, name, agent.Request{
+		Atime: toTimespec(atime),
+		Mtime: toTimespec(mtime),
+	})
+	return err
+}
+
+func toTimespec(t time.Time) agent.Timespec {
+	if t.IsZero() {
+		return agent.Timespec{}
+	}
+	return agent.Timespec{Sec: t.Unix(), Nsec: int64(t.Nanosecond())}
+}
+
+func toPodFileInfo(name string, st *agent.Stat) *PodFileInfo {
+	return &PodFileInfo{
+		name: name,
+		size: st.Size,
+		mode: toFileMode(st.Mode),
+		sys: LinuxStat_t{
+			Dev:     st.Dev,
+			Ino:     st.Ino,
+			Nlink:   st.Nlink,
+			Mode:    st.Mode,
+			Uid:     st.Uid,
+			Gid:     st.Gid,
+			Rdev:    st.Rdev,
+			Size:    st.Size,
+			Blksize: st.Blksize,
+			Blocks:  st.Blocks,
+			Atim:    syscall.Timespec{Sec: st.Atim.Sec, Nsec: st.Atim.Nsec},
+			Mtim:    syscall.Timespec{Sec: st.Mtim.Sec, Nsec: st.Mtim.Nsec},
+			Ctim:    syscall.Timespec{Sec: st.Ctim.Sec, Nsec: st.Ctim.Nsec},
+		},
+	}
+}
+
+// AgentFile is a file on the container opened by AgentFS.
+type AgentFile struct {
+	name string
+	fs   *AgentFS
+	off  int64
+
+	// entries is the rest of the directory entries for ReadDir, which are
+	// read at the first call.
+	entries []fs.DirEntry
+	dirRead bool
+}
+
+func (f *AgentFile) Stat() (fs.FileInfo, error) {
+	return f.fs.Stat(f.name)
+}
+
+func (f *AgentFile) Read(b []byte) (int, error) {
+	n, err := f.ReadAt(b, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *AgentFile) ReadAt(b []byte, off int64) (int, error) {
+	var n int
+	for n < len(b) {
+		size := len(b) - n
+		if size > agent.MaxReadSize {
+			size = agent.MaxReadSize
+		}
+		res, err := f.fs.call(agent.OpRead, f.name, agent.Request{
+			Offset: off + int64(n),
+			Size:   int64(size),
+		})
+		if err != nil {
+			return n, err
+		}
+		n += copy(b[n:], res.Data)
+		if len(res.Data) < size {
+			return n, io.EOF
+		}
+	}
+	return n, nil
+}
+
+func (f *AgentFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !f.dirRead {
+		entries, err := f.fs.ReadDir(f.name)
+		if err != nil {
+			return nil, err
+		}
+		f.entries = entries
+		f.dirRead = true
+	}
+	if n <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(f.entries) {
+		n = len(f.entries)
+	}
+	entries := f.entries[:n]
+	f.entries = f.entries[n:]
+	return entries, nil
+}
+
+func (f *AgentFile) Close() error {
+	return nil
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ueokande/kubectl-mount/pkg/agent"
+)
+
+// agentExecutor is an executor running the agent in the process for any
+// streaming commands.
+type agentExecutor struct {
+	Executor
+}
+
+func (e *agentExecutor) RunStream(ctx context.Context, command []string, stdin io.Reader, stdout io.Writer) error {
+	return agent.Serve(stdin, stdout)
+}
+
+// newTestAgentFS returns a filesystem on the directory served by the agent
+// over pipes.
+func newTestAgentFS(t *testing.T, dir string) *AgentFS {
+	t.Helper()
+	client := StartAgent(context.Background(), &agentExecutor{}, []string{defaultAgentPath})
+	t.Cleanup(func() { client.Close() })
+	return &AgentFS{Client: client, Pwd: dir}
+}
+
+func TestAgentFS(t *testing.T) {
+	dir := t.TempDir()
+	writeLocalFiles(t, dir, testFiles())
+	f := newTestAgentFS(t, dir)
+	if err := fstest.TestFS(f, "hello.txt", "dir/sub/deep", "dir/.hidden", "names/with\nnewline"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.WriteFile("new.txt", strings.NewReader("new file"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Rename("new.txt", "dir/renamed.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "dir/renamed.txt")); err != nil || string(data) != "new file" {
+		t.Errorf("content = %q, %v", data, err)
+	}
+	if _, err := f.Stat("new.txt"); !os.IsNotExist(err) {
+		t.Errorf("Stat of the renamed file: err = %v, want not exist", err)
+	}
+	if err := f.Remove("dir/renamed.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Remove("dir/renamed.txt"); !os.IsNotExist(err) {
+		t.Errorf("Remove of the missing file: err = %v, want not exist", err)
+	}
+	if err := f.Mkdir("hello.txt", 0755); !os.IsExist(err) {
+		t.Errorf("Mkdir: err = %v, want exist", err)
+	}
+	if err := f.Mkdir("setgid", os.ModeSetgid|0750); err != nil {
+		t.Fatal(err)
+	}
+	if inf, err := os.Stat(filepath.Join(dir, "setgid")); err != nil || inf.Mode() != os.ModeDir|os.ModeSetgid|0750 {
+		t.Errorf("stat = %v, %v, want mode dgrwxr-x---", inf, err)
+	}
+	if err := f.Chmod("hello.txt", os.ModeSticky|0644); err != nil {
+		t.Fatal(err)
+	}
+	if inf, err := os.Stat(filepath.Join(dir, "hello.txt")); err != nil || inf.Mode() != os.ModeSticky|0644 {
+		t.Errorf("stat = %v, %v, want mode trw-r--r--", inf, err)
+	}
+}
+
+func TestInstallAgent(t *testing.T) {
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip(err)
+	}
+	e := &LocalExecutor{}
+	remote := filepath.Join(t.TempDir(), "agent")
+	if err := InstallAgent(context.Background(), e, sleep, remote); err != nil {
+		t.Fatal(err)
+	}
+
+	// The agent of another mount is running from the same path
+	running := exec.Command(remote, "60")
+	if err := running.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = running.Process.Kill()
+		_ = running.Wait()
+	}()
+	if err := InstallAgent(context.Background(), e, sleep, remote); err != nil {
+		t.Fatal(err)
+	}
+	inf, err := os.Stat(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inf.Mode()&0111 == 0 {
+		t.Errorf("mode = %v, want executable", inf.Mode())
+	}
+	if entries, err := os.ReadDir(filepath.Dir(remote)); err != nil || len(entries) != 1 {
+		t.Errorf("temporary files are left: %v, %v", entries, err)
+	}
+
+	if err := InstallAgent(context.Background(), e, sleep, "/proc/agent"); err == nil {
+		t.Error("InstallAgent to an unwritable path succeeded")
+	}
+}
@@ -0,0 +1,256 @@
+package cmd
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// metadataCache holds results of stat, directory listing, readlink, extended
+// attributes and statfs with their expiration time.  Keys are the paths
+// relative to the root of the cached filesystem.
+type metadataCache struct {
+	mu      sync.Mutex
+	stats   map[string]cacheEntry
+	dirs    map[string]cacheEntry
+	links   map[string]cacheEntry
+	xattrs  map[string]cacheEntry
+	statfs  map[string]cacheEntry
+	attrTTL time.Duration
+	dirTTL  time.Duration
+}
+
+func (c *metadataCache) get(m map[string]cacheEntry, key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := m[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expires) {
+		delete(m, key)
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (c *metadataCache) put(m map[string]cacheEntry, key string, value interface{}, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m[key] = cacheEntry{value: value, expires: time.Now().Add(ttl)}
+}
+
+// invalidate removes cached results of the path, its descendants and the
+// listing of its parent directory.
+func (c *metadataCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, m := range []map[string]cacheEntry{c.stats, c.dirs, c.links, c.xattrs, c.statfs} {
+		for k := range m {
+			if k == key || key == "." || strings.HasPrefix(k, key+"/") {
+				delete(m, k)
+			}
+		}
+	}
+	delete(c.dirs, path.Dir(key))
+}
+
+// CachedFS is a filesystem which caches metadata of files on the underlying
+// filesystem.  File contents are not cached.  The cache is invalidated when
+// files are modified through the CachedFS.
+type CachedFS struct {
+	fsys   fs.FS
+	prefix string
+	cache  *metadataCache
+}
+
+// NewCachedFS returns a CachedFS which wraps fsys.  The attrTimeout is a
+// duration to cache attributes of files, extended attributes, targets of
+// symlinks and statistics of filesystems, and the entryTimeout is a duration
+// to cache entries in directories.
+func NewCachedFS(fsys fs.FS, attrTimeout, entryTimeout time.Duration) *CachedFS {
+	return &CachedFS{
+		fsys:   fsys,
+		prefix: ".",
+		cache: &metadataCache{
+			stats:   map[string]cacheEntry{},
+			dirs:    map[string]cacheEntry{},
+			links:   map[string]cacheEntry{},
+			xattrs:  map[string]cacheEntry{},
+			statfs:  map[string]cacheEntry{},
+			attrTTL: attrTimeout,
+			dirTTL:  entryTimeout,
+		},
+	}
+}
+
+func (f *CachedFS) key(name string) string {
+	return path.Join(f.prefix, name)
+}
+
+func (f *CachedFS) Open(name string) (fs.File, error) {
+	return f.fsys.Open(f.key(name))
+}
+
+func (f *CachedFS) Stat(name string) (fs.FileInfo, error) {
+	key := f.key(name)
+	if v, ok := f.cache.get(f.cache.stats, key); ok {
+		return v.(fs.FileInfo), nil
+	}
+	inf, err := fs.Stat(f.fsys, key)
+	if err != nil {
+		return nil, err
+	}
+	f.cache.put(f.cache.stats, key, inf, f.cache.attrTTL)
+	return inf, nil
+}
+
+func (f *CachedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	key := f.key(name)
+	if v, ok := f.cache.get(f.cache.dirs, key); ok {
+		return v.([]fs.DirEntry), nil
+	}
+	entries, err := fs.ReadDir(f.fsys, key)
+	if err != nil {
+		return nil, err
+	}
+	f.cache.put(f.cache.dirs, key, entries, f.cache.dirTTL)
+
+	// Entries from PodFS have attributes of the files, and they are used to
+	// the following lookups of the entries.
+	for _, e := range entries {
+		if e, ok := e.(*PodDirEntry); ok && e.info != nil {
+			f.cache.put(f.cache.stats, path.Join(key, e.Name()), e.info, f.cache.attrTTL)
+		}
+	}
+	return entries, nil
+}
+
+func (f *CachedFS) Readlink(name string) (string, error) {
+	key := f.key(name)
+	if v, ok := f.cache.get(f.cache.links, key); ok {
+		return v.(string), nil
+	}
+	target, err := Readlink(f.fsys, key)
+	if err != nil {
+		return "", err
+	}
+	f.cache.put(f.cache.links, key, target, f.cache.attrTTL)
+	return target, nil
+}
+
+func (f *CachedFS) Xattrs(name string) (map[string][]byte, error) {
+	key := f.key(name)
+	if v, ok := f.cache.get(f.cache.xattrs, key); ok {
+		return v.(map[string][]byte), nil
+	}
+	attrs, err := Xattrs(f.fsys, key)
+	if err != nil {
+		return nil, err
+	}
+	f.cache.put(f.cache.xattrs, key, attrs, f.cache.attrTTL)
+	return attrs, nil
+}
+
+func (f *CachedFS) Statfs(name string) (*LinuxStatfs_t, error) {
+	key := f.key(name)
+	if v, ok := f.cache.get(f.cache.statfs, key); ok {
+		return v.(*LinuxStatfs_t), nil
+	}
+	st, err := Statfs(f.fsys, key)
+	if err != nil {
+		return nil, err
+	}
+	f.cache.put(f.cache.statfs, key, st, f.cache.attrTTL)
+	return st, nil
+}
+
+func (f *CachedFS) Sub(dir string) (fs.FS, error) {
+	return &CachedFS{
+		fsys:   f.fsys,
+		prefix: f.key(dir),
+		cache:  f.cache,
+	}, nil
+}
+
+// Invalidate removes cached metadata of the file and its descendants.
+func (f *CachedFS) Invalidate(name string) {
+	f.cache.invalidate(f.key(name))
+}
+
+// Generation returns the generation of the underlying filesystem.
+func (f *CachedFS) Generation() uint64 {
+	return generation(f.fsys)
+}
+
+func (f *CachedFS) writableFS() WriteFS {
+	return f.fsys.(WriteFS)
+}
+
+func (f *CachedFS) WriteFile(name string, data io.Reader, perm fs.FileMode) error {
+	defer f.Invalidate(name)
+	return f.writableFS().WriteFile(f.key(name), data, perm)
+}
+
+func (f *CachedFS) Mkdir(name string, perm fs.FileMode) error {
+	defer f.Invalidate(name)
+	return f.writableFS().Mkdir(f.key(name), perm)
+}
+
+func (f *CachedFS) Remove(name string) error {
+	defer f.Invalidate(name)
+	return f.writableFS().Remove(f.key(name))
+}
+
+func (f *CachedFS) RemoveDir(name string) error {
+	defer f.Invalidate(name)
+	return f.writableFS().RemoveDir(f.key(name))
+}
+
+func (f *CachedFS) Rename(oldname, newname string) error {
+	defer f.Invalidate(oldname)
+	defer f.Invalidate(newname)
+	return f.writableFS().Rename(f.key(oldname), f.key(newname))
+}
+
+func (f *CachedFS) Symlink(target, name string) error {
+	defer f.Invalidate(name)
+	return f.writableFS().Symlink(target, f.key(name))
+}
+
+func (f *CachedFS) Link(oldname, newname string) error {
+	defer f.Invalidate(oldname)
+	defer f.Invalidate(newname)
+	return f.writableFS().Link(f.key(oldname), f.key(newname))
+}
+
+func (f *CachedFS) Chmod(name string, mode fs.FileMode) error {
+	defer f.Invalidate(name)
+	return f.writableFS().Chmod(f.key(name), mode)
+}
+
+func (f *CachedFS) Chown(name string, uid, gid int) error {
+	defer f.Invalidate(name)
+	return f.writableFS().Chown(f.key(name), uid, gid)
+}
+
+func (f *CachedFS) Truncate(name string, size int64) error {
+	defer f.Invalidate(name)
+	return f.writableFS().Truncate(f.key(name), size)
+}
+
+func (f *CachedFS) Chtimes(name string, atime, mtime time.Time) error {
+	defer f.Invalidate(name)
+	return f.writableFS().Chtimes(f.key(name), atime, mtime)
+}
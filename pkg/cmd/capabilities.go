@@ -0,0 +1,363 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Implementations of the core utilities in the container
+const (
+	userlandGNU     = "gnu"
+	userlandBusybox = "busybox"
+	userlandToybox  = "toybox"
+	userlandUnknown = "unknown"
+)
+
+// probedCommands is the commands whose availability is probed.
+var probedCommands = []string{
+	"find", "stat", "ls", "cat", "dd", "df", "readlink", "truncate",
+	"getfattr", "inotifywait", "busybox", "toybox",
+}
+
+// probeScript prints "command <name>" for the available commands, and the
+// features of stat and touch which differ between the userlands.  It also
+// prints the userland detected from the version of stat.
+const probeScript = `for c in "$@"; do
+	command -v "$c" >/dev/null 2>&1 && echo "command $c"
+done
+stat --printf "" / 2>/dev/null && echo "stat-printf"
+stat -c %i / >/dev/null 2>&1 && echo "stat-format"
+touch -c -d @0.000000001 /.kubectl-mount-probe 2>/dev/null && echo "touch-epoch"
+case $(stat --version 2>&1) in
+*"GNU coreutils"*) echo "userland gnu" ;;
+*BusyBox*) echo "userland busybox" ;;
+*toybox*) echo "userland toybox" ;;
+esac
+exit 0`
+
+// directProbes is the commands probing the capabilities without sh, for the
+// containers without sh.  Each command succeeds if the feature in the output
+// of probeScript is available.
+var directProbes = []struct {
+	feature string
+	command []string
+}{
+	{"command find", []string{"find", "/", "-maxdepth", "0"}},
+	{"command ls", []string{"ls", "-d", "/"}},
+	{"command cat", []string{"cat", "/dev/null"}},
+	{"command dd", []string{"dd", "if=/dev/null", "of=/dev/null", "count=0"}},
+	{"command df", []string{"df", "-P", "/"}},
+	{"command readlink", []string{"readlink", "/"}},
+	{"command truncate", []string{"truncate", "-c", "-s", "0", "/.kubectl-mount-probe"}},
+	{"command getfattr", []string{"getfattr", "--version"}},
+	{"command inotifywait", []string{"inotifywait", "--help"}},
+	{"command busybox", []string{"busybox", "true"}},
+	{"command toybox", []string{"toybox", "true"}},
+	{"stat-printf", []string{"stat", "--printf", "", "/"}},
+	{"stat-format", []string{"stat", "-c", "%i", "/"}},
+	{"touch-epoch", []string{"touch", "-c", "-d", "@0.000000001", "/.kubectl-mount-probe"}},
+}
+
+// Capabilities is the userland of the container, which is probed once when
+// the filesystem is mounted.  PodFS selects commands for each operation by
+// the capabilities instead of trying commands on each call.
+type Capabilities struct {
+	// Userland is the implementation of the core utilities, one of gnu,
+	// busybox, toybox and unknown.
+	Userland string
+
+	// Shell is true if the container has sh.  Without sh, only the
+	// operations running the commands directly are supported, and the
+	// filesystem is read-only.
+	Shell bool
+
+	// Commands is a set of the available commands in probedCommands.
+	Commands map[string]bool
+
+	// StatPrintf is true if stat supports --printf, which can terminate
+	// records by NUL.
+	StatPrintf bool
+
+	// StatFormat is true if stat supports -c.  It may be missing in
+	// restricted busybox builds.
+	StatFormat bool
+
+	// TouchEpoch is true if touch -d accepts seconds since the epoch with
+	// nanoseconds like @1234567890.123456789.
+	TouchEpoch bool
+}
+
+// gnuCapabilities is the capabilities assumed for PodFS without probing.
+var gnuCapabilities = func() *Capabilities {
+	c := &Capabilities{
+		Userland:   userlandGNU,
+		Shell:      true,
+		Commands:   map[string]bool{},
+		StatPrintf: true,
+		StatFormat: true,
+		TouchEpoch: true,
+	}
+	for _, name := range probedCommands {
+		c.Commands[name] = name != "busybox" && name != "toybox"
+	}
+	return c
+}()
+
+// ProbeCapabilities detects the userland of the container by a single
+// command.  If the container has no sh, the commands are probed one by one
+// by running them directly.  It fails if no commands run in the container.
+func ProbeCapabilities(ctx context.Context, e Executor) (*Capabilities, error) {
+	output, err := e.Run(ctx, append([]string{"sh", "-c", probeScript, "sh"}, probedCommands...))
+	if err != nil {
+		return probeDirectly(ctx, e, err)
+	}
+	c, err := parseProbe(output)
+	if err != nil {
+		return nil, err
+	}
+	c.Shell = true
+	return c, nil
+}
+
+// probeDirectly probes the capabilities by directProbes, and returns the
+// error of sh if no commands succeed.
+func probeDirectly(ctx context.Context, e Executor, shErr error) (*Capabilities, error) {
+	var output bytes.Buffer
+	for _, p := range directProbes {
+		if _, err := e.Run(ctx, p.command); err == nil {
+			fmt.Fprintln(&output, p.feature)
+			if strings.HasPrefix(p.feature, "stat-") {
+				fmt.Fprintln(&output, "command stat")
+			}
+		} else if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	if output.Len() == 0 {
+		return nil, shErr
+	}
+
+	// Usages of busybox are printed to stderr
+	version, err := e.Run(ctx, []string{"stat", "--version"})
+	var remoteErr *RemoteCommandErr
+	if errors.As(err, &remoteErr) {
+		version = append(version, remoteErr.Stderr...)
+	}
+	switch {
+	case bytes.Contains(version, []byte("GNU coreutils")):
+		fmt.Fprintln(&output, "userland gnu")
+	case bytes.Contains(version, []byte("BusyBox")):
+		fmt.Fprintln(&output, "userland busybox")
+	case bytes.Contains(version, []byte("toybox")):
+		fmt.Fprintln(&output, "userland toybox")
+	}
+	return parseProbe(output.Bytes())
+}
+
+// parseProbe parses the output of probeScript.
+func parseProbe(output []byte) (*Capabilities, error) {
+	c := &Capabilities{
+		Userland: userlandUnknown,
+		Commands: map[string]bool{},
+	}
+	s := bufio.NewScanner(bytes.NewReader(output))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		switch {
+		case len(fields) == 2 && fields[0] == "command":
+			c.Commands[fields[1]] = true
+		case len(fields) == 2 && fields[0] == "userland":
+			c.Userland = fields[1]
+		case len(fields) == 1 && fields[0] == "stat-printf":
+			c.StatPrintf = true
+		case len(fields) == 1 && fields[0] == "stat-format":
+			c.StatFormat = true
+		case len(fields) == 1 && fields[0] == "touch-epoch":
+			c.TouchEpoch = true
+		}
+	}
+	if c.Userland == userlandUnknown {
+		// stat is missing, but the applets may be available by the
+		// multi-call binary
+		switch {
+		case c.Commands["busybox"]:
+			c.Userland = userlandBusybox
+		case c.Commands["toybox"]:
+			c.Userland = userlandToybox
+		}
+	}
+	return c, s.Err()
+}
+
+// Has returns true if the command is available in the container.
+func (c *Capabilities) Has(command string) bool {
+	return c.Commands[command]
+}
+
+// Strategies of the operations.  The richer tools are preferred, and the
+// POSIX commands are used as fallbacks when the tools are missing.
+const (
+	// readDirFind stats all entries by find and GNU stat --printf
+	readDirFind = "find -exec stat --printf"
+	// readDirStat stats each entry by stat -c in a shell loop
+	readDirStat = "sh loop with stat -c"
+	// readDirLs lists each entry by ls -l in a shell loop
+	readDirLs = "sh loop with ls -l"
+
+	statStat = "stat -c"
+	statLs   = "ls -l"
+
+	statfsStat = "stat -f"
+	statfsDf   = "df -P"
+
+	readlinkReadlink = "readlink"
+	readlinkLs       = "ls -l"
+
+	truncateTruncate = "truncate"
+	truncateDd       = "dd"
+
+	touchEpoch     = "touch -d @seconds.nanoseconds"
+	touchTimestamp = "touch -t"
+
+	// copyModeStat copies the mode and the owner of the replaced file by
+	// stat -c, and copyModeCp copies them by cp -p
+	copyModeStat = "stat -c"
+	copyModeCp   = "cp -p"
+)
+
+// readDirStrategy returns the strategy to list directories, or an empty
+// string if directories cannot be listed.
+func (c *Capabilities) readDirStrategy() string {
+	switch {
+	case c.StatPrintf && c.Has("find"):
+		return readDirFind
+	case !c.Shell:
+		// The other strategies stat the entries in a shell loop
+	case c.StatFormat:
+		return readDirStat
+	case c.Has("ls"):
+		return readDirLs
+	}
+	return ""
+}
+
+func (c *Capabilities) statStrategy() string {
+	switch {
+	case c.StatFormat:
+		return statStat
+	case c.Has("ls"):
+		return statLs
+	}
+	return ""
+}
+
+func (c *Capabilities) statfsStrategy() string {
+	switch {
+	case c.StatFormat:
+		return statfsStat
+	case c.Has("df"):
+		return statfsDf
+	}
+	return ""
+}
+
+func (c *Capabilities) readlinkStrategy() string {
+	switch {
+	case c.Has("readlink"):
+		return readlinkReadlink
+	case c.Has("ls"):
+		return readlinkLs
+	}
+	return ""
+}
+
+func (c *Capabilities) truncateStrategy() string {
+	switch {
+	case !c.Shell:
+		return ""
+	case c.Has("truncate"):
+		return truncateTruncate
+	}
+	return truncateDd
+}
+
+func (c *Capabilities) touchStrategy() string {
+	switch {
+	case c.TouchEpoch:
+		return touchEpoch
+	case c.Shell:
+		return touchTimestamp
+	}
+	return ""
+}
+
+// copyModeStrategy returns the strategy to keep the mode of the replaced
+// file, or an empty string if files cannot be written without sh.
+func (c *Capabilities) copyModeStrategy() string {
+	switch {
+	case !c.Shell:
+		return ""
+	case c.StatFormat:
+		return copyModeStat
+	}
+	return copyModeCp
+}
+
+func (c *Capabilities) xattrsStrategy() string {
+	if c.Has("getfattr") {
+		return "getfattr"
+	}
+	return ""
+}
+
+func (c *Capabilities) watchStrategy() string {
+	if c.Shell && c.Has("inotifywait") {
+		return "inotifywait"
+	}
+	return ""
+}
+
+// Print prints the capabilities and the commands used for each operation as
+// tab-separated lines.
+func (c *Capabilities) Print(w io.Writer) {
+	var commands []string
+	for name, ok := range c.Commands {
+		if ok {
+			commands = append(commands, name)
+		}
+	}
+	sort.Strings(commands)
+
+	shell := "present"
+	if !c.Shell {
+		shell = "absent"
+	}
+	fmt.Fprintf(w, "Userland:\t%s\n", c.Userland)
+	fmt.Fprintf(w, "Shell:\t%s\n", shell)
+	fmt.Fprintf(w, "Commands:\t%s\n", strings.Join(commands, " "))
+	for _, op := range []struct {
+		name     string
+		strategy string
+	}{
+		{"List directories", c.readDirStrategy()},
+		{"Stat files", c.statStrategy()},
+		{"Statfs", c.statfsStrategy()},
+		{"Read symlinks", c.readlinkStrategy()},
+		{"Truncate files", c.truncateStrategy()},
+		{"Change times", c.touchStrategy()},
+		{"Keep modes on write", c.copyModeStrategy()},
+		{"Extended attributes", c.xattrsStrategy()},
+		{"Watch changes", c.watchStrategy()},
+	} {
+		if op.strategy == "" {
+			op.strategy = "unsupported"
+		}
+		fmt.Fprintf(w, "%s:\t%s\n", op.name, op.strategy)
+	}
+}
@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Fallbacks for containers with minimal userlands such as toybox and
+// restricted busybox, where stat has no -c and the attributes are read from
+// the output of ls -l and df -P.
+
+// lsFileTypes maps the file type character of ls -l to the file type bits.
+var lsFileTypes = map[byte]uint32{
+	'-': S_IFREG,
+	'd': S_IFDIR,
+	'l': S_IFLNK,
+	'b': S_IFBLK,
+	'c': S_IFCHR,
+	'p': S_IFIFO,
+	's': S_IFSOCK,
+}
+
+// parseLsMode parses the mode string of ls -l such as "drwxr-sr-t" into the
+// raw st_mode.
+func parseLsMode(s string) (uint32, error) {
+	// A trailing "." or "+" for the security context or the ACL is ignored
+	if len(s) < 10 {
+		return 0, fmt.Errorf("unexpected ls mode: %q", s)
+	}
+	rawmode, ok := lsFileTypes[s[0]]
+	if !ok {
+		return 0, fmt.Errorf("unexpected ls mode: %q", s)
+	}
+	for i, c := range s[1:10] {
+		bit := uint32(0400) >> i
+		switch c {
+		case 'r', 'w', 'x':
+			rawmode |= bit
+		case 's', 't':
+			rawmode |= bit | specialBit(i)
+		case 'S', 'T':
+			rawmode |= specialBit(i)
+		case '-':
+		default:
+			return 0, fmt.Errorf("unexpected ls mode: %q", s)
+		}
+	}
+	return rawmode, nil
+}
+
+// specialBit returns the setuid, setgid or sticky bit shown at the i-th
+// permission character of ls -l, or zero if the character cannot have it.
+func specialBit(i int) uint32 {
+	switch i {
+	case 2:
+		return syscall.S_ISUID
+	case 5:
+		return syscall.S_ISGID
+	case 8:
+		return syscall.S_ISVTX
+	}
+	return 0
+}
+
+// parseLsTime parses the modification time of ls -l in UTC.  It is either
+// "Jan 2 15:04" for recent files, "Jan 2 2006" for old files, or
+// "2006-01-02 15:04" by toybox.
+func parseLsTime(fields []string, now time.Time) (time.Time, error) {
+	if len(fields) >= 2 && strings.Count(fields[0], "-") == 2 {
+		return time.Parse("2006-01-02 15:04", fields[0]+" "+fields[1])
+	}
+	if len(fields) < 3 {
+		return time.Time{}, fmt.Errorf("unexpected ls time: %q", strings.Join(fields, " "))
+	}
+	if strings.Contains(fields[2], ":") {
+		t, err := time.Parse("Jan 2 15:04 2006", fmt.Sprintf("%s %s %s %d", fields[0], fields[1], fields[2], now.Year()))
+		if err == nil && t.After(now.Add(24*time.Hour)) {
+			// The year is omitted for the last six months
+			t = t.AddDate(-1, 0, 0)
+		}
+		return t, err
+	}
+	return time.Parse("Jan 2 2006", strings.Join(fields[:3], " "))
+}
+
+// parseLs parses a line of ls -dinl output, which consists of the inode
+// number, the mode, the number of links, the owner, the group, the size or
+// the device numbers, the modification time and the name.  ls does not show
+// the access and the change times, so they are set to the modification
+// time, and the times have only minutes precision.
+func parseLs(name, line string) (*PodFileInfo, error) {
+	return parseLsAt(name, line, time.Now().UTC())
+}
+
+func parseLsAt(name, line string, now time.Time) (*PodFileInfo, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 8 {
+		return nil, fmt.Errorf("unexpected ls output: %q", line)
+	}
+
+	var err error
+	parseUint := func(s string) uint64 {
+		n, e := strconv.ParseUint(s, 10, 64)
+		if e != nil && err == nil {
+			err = e
+		}
+		return n
+	}
+
+	rawmode, err := parseLsMode(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	st := LinuxStat_t{
+		Ino:   parseUint(fields[0]),
+		Mode:  rawmode,
+		Nlink: parseUint(fields[2]),
+		Uid:   uint32(parseUint(fields[3])),
+		Gid:   uint32(parseUint(fields[4])),
+	}
+	rest := fields[5:]
+	if ft := rawmode & S_IFMT; ft == S_IFBLK || ft == S_IFCHR {
+		// The device numbers are shown as "1, 3" or "1,3"
+		major, minor := rest[0], ""
+		if i := strings.IndexByte(major, ','); i >= 0 {
+			major, minor = major[:i], major[i+1:]
+		}
+		rest = rest[1:]
+		if minor == "" {
+			minor, rest = rest[0], rest[1:]
+		}
+		st.Rdev = mkdev(parseUint(major), parseUint(minor))
+	} else {
+		st.Size = int64(parseUint(rest[0]))
+		st.Blocks = (st.Size + 511) / 512
+		rest = rest[1:]
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mtime, err := parseLsTime(rest, now)
+	if err != nil {
+		return nil, err
+	}
+	st.Mtim = syscall.NsecToTimespec(mtime.UnixNano())
+	st.Atim = st.Mtim
+	st.Ctim = st.Mtim
+	return &PodFileInfo{
+		name: name,
+		size: st.Size,
+		mode: toFileMode(rawmode),
+		sys:  st,
+	}, nil
+}
+
+// parseLsLink returns the target of the symlink p from the output of ls -dl,
+// which shows it after "p -> ".
+func parseLsLink(p, output string) (string, error) {
+	i := strings.Index(output, " "+p+" -> ")
+	if i < 0 {
+		return "", &fs.PathError{Op: "readlink", Path: p, Err: syscall.EINVAL}
+	}
+	return strings.TrimSuffix(output[i+len(p)+5:], "\n"), nil
+}
+
+// parseDf parses the output of df -P -k.  The capacity is followed by the
+// mountpoint, and preceded by the total, the used and the available blocks
+// in KiB.  The name of the filesystem can contain spaces, so the fields are
+// located from the capacity.  df does not show the number of inodes.
+func parseDf(output []byte) (*LinuxStatfs_t, error) {
+	s := bufio.NewScanner(bytes.NewReader(output))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		for i := 3; i < len(fields); i++ {
+			if !strings.HasSuffix(fields[i], "%") {
+				continue
+			}
+			total, err1 := strconv.ParseUint(fields[i-3], 10, 64)
+			used, err2 := strconv.ParseUint(fields[i-2], 10, 64)
+			avail, err3 := strconv.ParseUint(fields[i-1], 10, 64)
+			if err1 != nil || err2 != nil || err3 != nil {
+				// The header line
+				break
+			}
+			free := avail
+			if total > used {
+				free = total - used
+			}
+			return &LinuxStatfs_t{
+				Bsize:   1024,
+				Frsize:  1024,
+				Blocks:  total,
+				Bfree:   free,
+				Bavail:  avail,
+				Namelen: 255,
+			}, nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("unexpected df output: %s", output)
+}
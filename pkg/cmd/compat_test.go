@@ -0,0 +1,472 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// recordingExecutor records commands and replies the canned output for the
+// first word of the command.  The commands in missing fail like missing
+// executables.
+type recordingExecutor struct {
+	outputs  map[string]string
+	missing  map[string]bool
+	commands [][]string
+}
+
+func (e *recordingExecutor) Run(ctx context.Context, command []string) ([]byte, error) {
+	e.commands = append(e.commands, command)
+	if e.missing[command[0]] {
+		return nil, &RemoteCommandErr{
+			Stderr: []byte(command[0] + ": executable file not found in $PATH"),
+			Err:    errors.New("command terminated with exit code 127"),
+		}
+	}
+	return []byte(e.outputs[command[0]]), nil
+}
+
+func (e *recordingExecutor) RunRead(ctx context.Context, command []string) (io.ReadCloser, error) {
+	e.commands = append(e.commands, command)
+	return io.NopCloser(strings.NewReader(e.outputs[command[0]])), nil
+}
+
+func (e *recordingExecutor) RunWrite(ctx context.Context, command []string, stdin io.Reader) error {
+	e.commands = append(e.commands, command)
+	return nil
+}
+
+func (e *recordingExecutor) RunStream(ctx context.Context, command []string, stdin io.Reader, stdout io.Writer) error {
+	e.commands = append(e.commands, command)
+	return errors.New("not supported")
+}
+
+func TestProbeCapabilities(t *testing.T) {
+	tests := []struct {
+		flavor   string
+		output   string
+		userland string
+		strategy map[string]string
+	}{
+		{
+			flavor: "gnu",
+			output: "command find\ncommand stat\ncommand ls\ncommand df\ncommand readlink\ncommand truncate\n" +
+				"stat-printf\nstat-format\ntouch-epoch\nuserland gnu\n",
+			userland: userlandGNU,
+			strategy: map[string]string{
+				"readdir": readDirFind, "stat": statStat, "statfs": statfsStat, "readlink": readlinkReadlink,
+				"truncate": truncateTruncate, "touch": touchEpoch, "copymode": copyModeStat,
+			},
+		},
+		{
+			flavor: "busybox",
+			output: "command find\ncommand stat\ncommand ls\ncommand df\ncommand readlink\ncommand truncate\ncommand busybox\n" +
+				"stat-format\ntouch-epoch\nuserland busybox\n",
+			userland: userlandBusybox,
+			strategy: map[string]string{
+				"readdir": readDirStat, "stat": statStat, "statfs": statfsStat, "readlink": readlinkReadlink,
+				"truncate": truncateTruncate, "touch": touchEpoch, "copymode": copyModeStat,
+			},
+		},
+		{
+			flavor: "toybox",
+			output: "command find\ncommand ls\ncommand df\ncommand readlink\ncommand truncate\ncommand toybox\n" +
+				"touch-epoch\n",
+			userland: userlandToybox,
+			strategy: map[string]string{
+				"readdir": readDirLs, "stat": statLs, "statfs": statfsDf, "readlink": readlinkReadlink,
+				"truncate": truncateTruncate, "touch": touchEpoch, "copymode": copyModeCp,
+			},
+		},
+		{
+			flavor:   "restricted busybox",
+			output:   "command ls\ncommand df\ncommand busybox\nuserland busybox\n",
+			userland: userlandBusybox,
+			strategy: map[string]string{
+				"readdir": readDirLs, "stat": statLs, "statfs": statfsDf, "readlink": readlinkLs,
+				"truncate": truncateDd, "touch": touchTimestamp, "copymode": copyModeCp,
+			},
+		},
+		{
+			flavor:   "posix without ls",
+			output:   "",
+			userland: userlandUnknown,
+			strategy: map[string]string{
+				"readdir": "", "stat": "", "statfs": "", "readlink": "",
+				"truncate": truncateDd, "touch": touchTimestamp, "copymode": copyModeCp,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.flavor, func(t *testing.T) {
+			e := &recordingExecutor{outputs: map[string]string{"sh": tt.output}}
+			c, err := ProbeCapabilities(context.Background(), e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Userland != tt.userland {
+				t.Errorf("userland = %q, want %q", c.Userland, tt.userland)
+			}
+			got := map[string]string{
+				"readdir":  c.readDirStrategy(),
+				"stat":     c.statStrategy(),
+				"statfs":   c.statfsStrategy(),
+				"readlink": c.readlinkStrategy(),
+				"truncate": c.truncateStrategy(),
+				"touch":    c.touchStrategy(),
+				"copymode": c.copyModeStrategy(),
+			}
+			if !reflect.DeepEqual(got, tt.strategy) {
+				t.Errorf("strategies = %v, want %v", got, tt.strategy)
+			}
+		})
+	}
+}
+
+func TestProbeCapabilitiesWithoutShell(t *testing.T) {
+	// A distroless image with GNU coreutils but without sh
+	e := &recordingExecutor{
+		outputs: map[string]string{"stat": "stat (GNU coreutils) 8.32\n"},
+		missing: map[string]bool{"sh": true, "busybox": true, "toybox": true, "getfattr": true},
+	}
+	c, err := ProbeCapabilities(context.Background(), e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Shell || c.Userland != userlandGNU || !c.StatPrintf || !c.Has("find") || !c.Has("stat") || c.Has("busybox") {
+		t.Errorf("unexpected capabilities %+v", c)
+	}
+	if !c.Has("truncate") || !c.Has("inotifywait") {
+		t.Errorf("commands = %v, want truncate and inotifywait", c.Commands)
+	}
+	got := map[string]string{
+		"readdir":  c.readDirStrategy(),
+		"truncate": c.truncateStrategy(),
+		"touch":    c.touchStrategy(),
+		"copymode": c.copyModeStrategy(),
+		"watch":    c.watchStrategy(),
+	}
+	want := map[string]string{"readdir": readDirFind, "truncate": "", "touch": touchEpoch, "copymode": "", "watch": ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("strategies = %v, want %v", got, want)
+	}
+	var shells int
+	for _, command := range e.commands {
+		if command[0] == "sh" {
+			shells++
+		}
+	}
+	if shells != 1 {
+		t.Errorf("sh is run %d times, want once", shells)
+	}
+	var out strings.Builder
+	c.Print(&out)
+	if !strings.Contains(out.String(), "Shell:\tabsent\n") {
+		t.Errorf("output does not show the absent shell:\n%s", out.String())
+	}
+
+	// Without sh, the busybox strategies running shell loops are not used
+	e = &recordingExecutor{missing: map[string]bool{"sh": true, "find": true, "stat": true}}
+	c, err = ProbeCapabilities(context.Background(), e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.readDirStrategy() != "" || c.statStrategy() != statLs {
+		t.Errorf("strategies = %q, %q, want none and %q", c.readDirStrategy(), c.statStrategy(), statLs)
+	}
+
+	// The error of sh is returned if no commands run
+	missing := map[string]bool{"sh": true}
+	for _, p := range directProbes {
+		missing[p.command[0]] = true
+	}
+	e = &recordingExecutor{missing: missing}
+	if _, err := ProbeCapabilities(context.Background(), e); err == nil || !strings.Contains(err.Error(), "sh: executable file not found") {
+		t.Errorf("err = %v, want the error of sh", err)
+	}
+}
+
+func TestParseLs(t *testing.T) {
+	now := time.Date(2026, 1, 5, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		flavor string
+		line   string
+		mode   uint32
+		size   int64
+		nlink  uint64
+		rdev   uint64
+		mtime  time.Time
+	}{
+		{
+			flavor: "gnu recent",
+			line:   "1234 -rw-r--r-- 1 1000 1000 42 Jan  4 10:30 file\n",
+			mode:   S_IFREG | 0644, size: 42, nlink: 1,
+			mtime: time.Date(2026, 1, 4, 10, 30, 0, 0, time.UTC),
+		},
+		{
+			flavor: "gnu last year",
+			line:   "1234 -rw-r--r-- 1 1000 1000 42 Dec 30 23:59 file",
+			mode:   S_IFREG | 0644, size: 42, nlink: 1,
+			mtime: time.Date(2025, 12, 30, 23, 59, 0, 0, time.UTC),
+		},
+		{
+			flavor: "gnu old with selinux context",
+			line:   "1234 drwxr-xr-x. 3 0 0 4096 Mar  1  2020 dir",
+			mode:   S_IFDIR | 0755, size: 4096, nlink: 3,
+			mtime: time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			flavor: "gnu device",
+			line:   "6 crw-rw-rw- 1 0 0 1, 3 Jan  1 00:00 /dev/null",
+			mode:   S_IFCHR | 0666, nlink: 1, rdev: mkdev(1, 3),
+			mtime: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			flavor: "busybox",
+			line:   "  1234 -rwsr-x---    2 0        0             123 Jan  4 10:30 file",
+			mode:   S_IFREG | syscall.S_ISUID | 0750, size: 123, nlink: 2,
+			mtime: time.Date(2026, 1, 4, 10, 30, 0, 0, time.UTC),
+		},
+		{
+			flavor: "busybox device",
+			line:   "  6 brw-rw----    1 0        6          8,  16 Jan  4 10:30 /dev/sdb",
+			mode:   S_IFBLK | 0660, nlink: 1, rdev: mkdev(8, 16),
+			mtime: time.Date(2026, 1, 4, 10, 30, 0, 0, time.UTC),
+		},
+		{
+			flavor: "toybox",
+			line:   "1234 drwxrwxrwt 2 0 0 40 2025-12-24 08:15 tmp",
+			mode:   S_IFDIR | syscall.S_ISVTX | 0777, size: 40, nlink: 2,
+			mtime: time.Date(2025, 12, 24, 8, 15, 0, 0, time.UTC),
+		},
+		{
+			flavor: "toybox device",
+			line:   "6 crw-rw-rw- 1 0 0 1,   3 2025-12-24 08:15 /dev/null",
+			mode:   S_IFCHR | 0666, nlink: 1, rdev: mkdev(1, 3),
+			mtime: time.Date(2025, 12, 24, 8, 15, 0, 0, time.UTC),
+		},
+		{
+			flavor: "toybox symlink",
+			line:   "99 lrwxrwxrwx 1 0 0 6 2025-12-24 08:15 link -> target",
+			mode:   S_IFLNK | 0777, size: 6, nlink: 1,
+			mtime: time.Date(2025, 12, 24, 8, 15, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.flavor, func(t *testing.T) {
+			inf, err := parseLsAt("name", tt.line, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			st := inf.sys
+			if st.Mode != tt.mode {
+				t.Errorf("mode = %o, want %o", st.Mode, tt.mode)
+			}
+			if inf.Size() != tt.size || st.Nlink != tt.nlink || st.Rdev != tt.rdev {
+				t.Errorf("size, nlink, rdev = %d, %d, %x, want %d, %d, %x", inf.Size(), st.Nlink, st.Rdev, tt.size, tt.nlink, tt.rdev)
+			}
+			if !inf.ModTime().Equal(tt.mtime) {
+				t.Errorf("mtime = %v, want %v", inf.ModTime().UTC(), tt.mtime)
+			}
+			if st.Ino == 0 || st.Atim != st.Mtim || st.Ctim != st.Mtim {
+				t.Errorf("unexpected stat: %+v", st)
+			}
+		})
+	}
+
+	for _, line := range []string{"", "1234 ?rw-r--r-- 1 0 0 42 Jan 4 10:30 file", "x -rw-r--r-- 1 0 0 42 Jan 4 10:30 file"} {
+		if _, err := parseLsAt("name", line, now); err == nil {
+			t.Errorf("parseLs(%q) succeeded", line)
+		}
+	}
+}
+
+func TestParseLsLink(t *testing.T) {
+	tests := []struct {
+		flavor string
+		output string
+		want   string
+	}{
+		{"gnu", "lrwxrwxrwx 1 root root 6 Jan  4 10:30 /tmp/link -> target\n", "target"},
+		{"busybox", "lrwxrwxrwx    1 root     root            14 Jan  4 10:30 /tmp/link -> /a -> b\n", "/a -> b"},
+		{"toybox", "lrwxrwxrwx 1 root root 9 2025-12-24 08:15 /tmp/link -> ../target\n", "../target"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.flavor, func(t *testing.T) {
+			got, err := parseLsLink("/tmp/link", tt.output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("target = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	_, err := parseLsLink("/tmp/file", "-rw-r--r-- 1 root root 0 Jan  4 10:30 /tmp/file\n")
+	if !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("err = %v, want EINVAL", err)
+	}
+}
+
+func TestParseDf(t *testing.T) {
+	tests := []struct {
+		flavor string
+		output string
+		want   LinuxStatfs_t
+	}{
+		{
+			flavor: "gnu",
+			output: "Filesystem     1024-blocks    Used Available Capacity Mounted on\n" +
+				"overlay          102400   40960     56320      43% /\n",
+			want: LinuxStatfs_t{Bsize: 1024, Frsize: 1024, Blocks: 102400, Bfree: 61440, Bavail: 56320, Namelen: 255},
+		},
+		{
+			flavor: "busybox",
+			output: "Filesystem           1024-blocks    Used Available Capacity Mounted on\n" +
+				"/dev/vda1                 1000       0      1000   0% /data\n",
+			want: LinuxStatfs_t{Bsize: 1024, Frsize: 1024, Blocks: 1000, Bfree: 1000, Bavail: 1000, Namelen: 255},
+		},
+		{
+			flavor: "toybox with spaces in the filesystem",
+			output: "Filesystem 1K-blocks Used Available Use% Mounted on\n" +
+				"my volume 2048 1024 512 67% /mnt/my data\n",
+			want: LinuxStatfs_t{Bsize: 1024, Frsize: 1024, Blocks: 2048, Bfree: 1024, Bavail: 512, Namelen: 255},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.flavor, func(t *testing.T) {
+			got, err := parseDf([]byte(tt.output))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Errorf("statfs = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+
+	if _, err := parseDf([]byte("df: /x: No such file or directory\n")); err == nil {
+		t.Error("parseDf succeeded for an error message")
+	}
+}
+
+func TestPodFSCommands(t *testing.T) {
+	toybox := &Capabilities{Userland: userlandToybox, Shell: true, Commands: map[string]bool{"ls": true, "df": true}}
+	tests := []struct {
+		flavor string
+		caps   *Capabilities
+		op     func(f *PodFS) error
+		want   []string
+	}{
+		{
+			flavor: "gnu stat",
+			caps:   gnuCapabilities,
+			op:     func(f *PodFS) error { _, err := f.Stat("a"); return err },
+			want:   []string{"stat", "-c", statFormat, "--", "/root/a"},
+		},
+		{
+			flavor: "toybox stat",
+			caps:   toybox,
+			op:     func(f *PodFS) error { _, err := f.Stat("a"); return err },
+			want:   []string{"ls", "-dinl", "--", "/root/a"},
+		},
+		{
+			flavor: "busybox readdir",
+			caps:   &Capabilities{Userland: userlandBusybox, Shell: true, StatFormat: true},
+			op:     func(f *PodFS) error { _, err := f.ReadDir("d"); return err },
+			want:   []string{"sh", "-c", readDirScript, "sh", "/root/d/.", "stat", "-c", statFormat, "--"},
+		},
+		{
+			flavor: "toybox readdir",
+			caps:   toybox,
+			op:     func(f *PodFS) error { _, err := f.ReadDir("d"); return err },
+			want:   []string{"sh", "-c", readDirScript, "sh", "/root/d/.", "ls", "-dinl", "--"},
+		},
+		{
+			flavor: "toybox statfs",
+			caps:   toybox,
+			op:     func(f *PodFS) error { _, err := f.Statfs("."); return err },
+			want:   []string{"df", "-P", "-k", "--", "/root"},
+		},
+		{
+			flavor: "toybox readlink",
+			caps:   toybox,
+			op:     func(f *PodFS) error { _, err := f.Readlink("l"); return err },
+			want:   []string{"ls", "-dl", "--", "/root/l"},
+		},
+		{
+			flavor: "toybox truncate",
+			caps:   toybox,
+			op:     func(f *PodFS) error { return f.Truncate("a", 10) },
+			want:   []string{"dd", "if=/dev/null", "of=/root/a", "bs=1", "seek=10"},
+		},
+		{
+			flavor: "gnu touch",
+			caps:   gnuCapabilities,
+			op:     func(f *PodFS) error { return f.Chtimes("a", time.Unix(1500000000, 789), time.Time{}) },
+			want:   []string{"touch", "-c", "-a", "-d", "@1500000000.000000789", "/root/a"},
+		},
+		{
+			flavor: "gnu touch before the epoch",
+			caps:   gnuCapabilities,
+			op:     func(f *PodFS) error { return f.Chtimes("a", time.Time{}, time.Unix(-2, 250000000)) },
+			want:   []string{"touch", "-c", "-m", "-d", "@-1.750000000", "/root/a"},
+		},
+		{
+			flavor: "toybox touch",
+			caps:   toybox,
+			op:     func(f *PodFS) error { return f.Chtimes("a", time.Time{}, time.Unix(1500000000, 0)) },
+			want:   []string{"sh", "-c", touchScript, "sh", "-c", "-m", "-t", "201707140240.00", "/root/a"},
+		},
+		{
+			flavor: "toybox write",
+			caps:   toybox,
+			op:     func(f *PodFS) error { return f.WriteFile("a", strings.NewReader(""), 0644) },
+			want:   []string{"sh", "-c", writeFileScript, "sh", "/root/a", "644", copyModeCp},
+		},
+		{
+			flavor: "gnu chmod setgid",
+			caps:   gnuCapabilities,
+			op:     func(f *PodFS) error { return f.Chmod("d", fs.ModeSetgid|0755) },
+			want:   []string{"chmod", "2755", "/root/d"},
+		},
+		{
+			flavor: "gnu mkdir sticky",
+			caps:   gnuCapabilities,
+			op:     func(f *PodFS) error { return f.Mkdir("d", fs.ModeSticky|0777) },
+			want:   []string{"mkdir", "-m", "1777", "/root/d"},
+		},
+		{
+			flavor: "gnu remove",
+			caps:   gnuCapabilities,
+			op:     func(f *PodFS) error { return f.Remove("a") },
+			want:   []string{"rm", "/root/a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.flavor, func(t *testing.T) {
+			e := &recordingExecutor{outputs: map[string]string{
+				"stat": strings.Repeat("0\t", statFields-1) + "0\n",
+				"ls":   "1 lrwxrwxrwx 1 0 0 1 2025-12-24 08:15 /root/l -> x\n",
+				"df":   "Filesystem 1K-blocks Used Available Use% Mounted on\nfs 1 1 0 100% /\n",
+			}}
+			f := &PodFS{Executor: e, Pwd: "/root", Capabilities: tt.caps}
+			if err := tt.op(f); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				t.Fatal(err)
+			}
+			if len(e.commands) != 1 {
+				t.Fatalf("commands = %q, want one command", e.commands)
+			}
+			if !reflect.DeepEqual(e.commands[0], tt.want) {
+				t.Errorf("command = %q, want %q", e.commands[0], tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// backgroundEnv is set to the mount process started by runBackground.
+const backgroundEnv = "KUBECTL_MOUNT_BACKGROUND"
+
+// readyFd is the file descriptor of the pipe to notify the parent process
+// that the filesystem is mounted.
+const readyFd = 3
+
+// inBackground returns true if the process is the mount process started in
+// the background.
+func inBackground() bool {
+	return os.Getenv(backgroundEnv) != ""
+}
+
+// runBackground starts the mount process with the same arguments in a new
+// session, and waits until the process mounts the filesystem.  The output
+// of the process is written to the log in the state directory.
+func (o *MountOptions) runBackground() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	dir, err := stateDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	logFile, err := os.CreateTemp(dir, "*.log")
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), backgroundEnv+"=1")
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.ExtraFiles = []*os.File{w}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		os.Remove(logFile.Name())
+		return err
+	}
+
+	// The log is named after the PID as same as the state of the mount
+	logPath, err := statePath(cmd.Process.Pid, ".log")
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(logFile.Name(), logPath); err != nil {
+		return err
+	}
+
+	// The process closes the pipe without any messages when it fails
+	msg, err := io.ReadAll(r)
+	if err != nil || len(msg) == 0 {
+		cmd.Wait()
+		output, _ := os.ReadFile(logPath)
+		os.Remove(logPath)
+		return fmt.Errorf("unable to mount in the background:\n%s", strings.TrimSpace(string(output)))
+	}
+	fmt.Fprintf(o.ErrOut, "%s in the background (pid %d)\n", strings.TrimSpace(string(msg)), cmd.Process.Pid)
+	return cmd.Process.Release()
+}
+
+// notifyMounted notifies the parent process that the filesystem is mounted
+// with the message, if the process runs in the background.
+func notifyMounted(msg string) {
+	if !inBackground() {
+		return
+	}
+	f := os.NewFile(readyFd, "ready")
+	fmt.Fprint(f, msg)
+	f.Close()
+}
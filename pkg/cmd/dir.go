@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+
+	fusefs "github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// openFunc creates a node of the entry in DirNode, and returns a function to
+// release resources of the node.
+type openFunc func() (fusefs.InodeEmbedder, func(), error)
+
+// DirNode is a read-only synthetic directory whose entries are added and
+// removed dynamically, such as pods matching the selector.  A node of the
+// entry is created on the first lookup.
+type DirNode struct {
+	fusefs.Inode
+
+	mu       sync.Mutex
+	entries  map[string]openFunc
+	releases map[string]func()
+}
+
+var _ = (fusefs.NodeReaddirer)((*DirNode)(nil))
+var _ = (fusefs.NodeLookuper)((*DirNode)(nil))
+var _ = (fusefs.NodeGetattrer)((*DirNode)(nil))
+
+func NewDirNode() *DirNode {
+	return &DirNode{
+		entries:  map[string]openFunc{},
+		releases: map[string]func(){},
+	}
+}
+
+// Add adds the entry to the directory.  It does nothing if the entry
+// already exists.
+func (n *DirNode) Add(name string, open openFunc) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.entries[name]; !ok {
+		n.entries[name] = open
+	}
+}
+
+// Remove removes the entry from the directory, and releases the node of the
+// entry.
+func (n *DirNode) Remove(name string) {
+	n.mu.Lock()
+	delete(n.entries, name)
+	release := n.releases[name]
+	delete(n.releases, name)
+	n.mu.Unlock()
+
+	if ch := n.GetChild(name); ch != nil {
+		n.RmChild(name)
+		n.NotifyEntry(name)
+	}
+	if release != nil {
+		release()
+	}
+}
+
+// Close releases nodes of all entries.
+func (n *DirNode) Close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for name, release := range n.releases {
+		release()
+		delete(n.releases, name)
+	}
+}
+
+func (n *DirNode) Readdir(ctx context.Context) (fusefs.DirStream, syscall.Errno) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	names := make([]string, 0, len(n.entries))
+	for name := range n.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	entries := make([]fuse.DirEntry, len(names))
+	for i, name := range names {
+		entries[i] = fuse.DirEntry{Mode: fuse.S_IFDIR, Name: name}
+	}
+	return fusefs.NewListDirStream(entries), 0
+}
+
+func (n *DirNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fusefs.Inode, syscall.Errno) {
+	ch, errno := n.lookup(ctx, name)
+	if errno != 0 {
+		return nil, errno
+	}
+	if node, ok := ch.Operations().(fusefs.NodeGetattrer); ok {
+		var attr fuse.AttrOut
+		if errno := node.Getattr(ctx, nil, &attr); errno != 0 {
+			return nil, errno
+		}
+		out.Attr = attr.Attr
+	}
+	return ch, fusefs.OK
+}
+
+// lookup returns the node of the entry, and creates the node on the first
+// lookup.  Creating the node may run commands in the pod, so it runs without
+// holding the lock, and the node is discarded if another lookup adds the node
+// or the entry is removed meanwhile.
+func (n *DirNode) lookup(ctx context.Context, name string) (*fusefs.Inode, syscall.Errno) {
+	n.mu.Lock()
+	open, ok := n.entries[name]
+	n.mu.Unlock()
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	if ch := n.GetChild(name); ch != nil {
+		return ch, fusefs.OK
+	}
+
+	node, release, err := open()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to open %s: %v\n", name, err)
+		return nil, syscall.EIO
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.entries[name]; !ok {
+		release()
+		return nil, syscall.ENOENT
+	}
+	if ch := n.GetChild(name); ch != nil {
+		release()
+		return ch, fusefs.OK
+	}
+	ch := n.NewPersistentInode(ctx, node, fusefs.StableAttr{Mode: fuse.S_IFDIR})
+	n.AddChild(name, ch, false)
+	n.releases[name] = release
+	return ch, fusefs.OK
+}
+
+func (n *DirNode) Getattr(ctx context.Context, f fusefs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = fuse.S_IFDIR | 0555
+	return fusefs.OK
+}
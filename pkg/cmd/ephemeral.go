@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const ephemeralContainerPrefix = "kubectl-mount-"
+
+// findTargetPIDScript prints the PID of the first process in the container
+// whose ID is $1.  The process in the target container is usually PID 1, but
+// the pod may share the process namespace with the pause process, sidecars
+// and other ephemeral containers.  The processes of the container are found
+// by the ID in their cgroups, or in the mounts such as /etc/hostname on
+// Docker.
+const findTargetPIDScript = `for pid in $(ls /proc | grep '^[0-9][0-9]*$' | sort -n); do
+	if grep -q -F "$1" /proc/$pid/cgroup /proc/$pid/mountinfo 2>/dev/null; then
+		echo "$pid"
+		exit 0
+	fi
+done
+echo "no process of the container $1 is visible" >&2
+exit 1
+`
+
+// ensureEphemeralContainer returns a name of the running ephemeral container
+// targeting the container.  It reuses the ephemeral container created by
+// previous mounts if it is still running, or it creates a new one.
+// Ephemeral containers cannot be removed from the pod, so they are left
+// running for the following mounts.  It waits for the new ephemeral container
+// to be running until the timeout.
+func ensureEphemeralContainer(ctx context.Context, api kubernetes.Interface, pod *corev1.Pod, target, image string, timeout time.Duration) (string, error) {
+	for _, c := range pod.Spec.EphemeralContainers {
+		if !strings.HasPrefix(c.Name, ephemeralContainerPrefix) || c.TargetContainerName != target || c.Image != image {
+			continue
+		}
+		if s := ephemeralContainerStatus(pod, c.Name); s != nil && s.State.Running != nil {
+			return c.Name, nil
+		}
+	}
+
+	name := ephemeralContainerPrefix + utilrand.String(5)
+	pod = pod.DeepCopy()
+	pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, corev1.EphemeralContainer{
+		TargetContainerName: target,
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:                     name,
+			Image:                    image,
+			Command:                  []string{"sh", "-c", "while true; do sleep 3600; done"},
+			ImagePullPolicy:          corev1.PullIfNotPresent,
+			TerminationMessagePolicy: corev1.TerminationMessageReadFile,
+			SecurityContext: &corev1.SecurityContext{
+				// Required to access the root directory of the processes
+				// owned by the other users via /proc/<pid>/root
+				Capabilities: &corev1.Capabilities{
+					Add: []corev1.Capability{"SYS_PTRACE"},
+				},
+			},
+		},
+	})
+	_, err := api.CoreV1().Pods(pod.Namespace).UpdateEphemeralContainers(ctx, pod.Name, pod, metav1.UpdateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("unable to create an ephemeral container: %w", err)
+	}
+
+	err = wait.PollImmediate(time.Second, timeout, func() (bool, error) {
+		pod, err := api.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		s := ephemeralContainerStatus(pod, name)
+		if s == nil {
+			return false, nil
+		}
+		if s.State.Terminated != nil {
+			return false, fmt.Errorf("ephemeral container %s terminated: %s", name, s.State.Terminated.Reason)
+		}
+		return s.State.Running != nil, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("ephemeral container %s is not running: %w", name, err)
+	}
+	return name, nil
+}
+
+func ephemeralContainerStatus(pod *corev1.Pod, name string) *corev1.ContainerStatus {
+	for i, s := range pod.Status.EphemeralContainerStatuses {
+		if s.Name == name {
+			return &pod.Status.EphemeralContainerStatuses[i]
+		}
+	}
+	return nil
+}
+
+// containerID returns the ID of the container in the pod without the prefix
+// of the container runtime, such as containerd://.
+func containerID(pod *corev1.Pod, name string) (string, error) {
+	s := findContainerStatus(pod, name)
+	if s == nil || s.ContainerID == "" {
+		return "", fmt.Errorf("container %s in pod %s has no container ID", name, pod.Name)
+	}
+	id := s.ContainerID
+	if i := strings.Index(id, "://"); i >= 0 {
+		id = id[i+len("://"):]
+	}
+	return id, nil
+}
+
+// findTargetRoot returns a path to the root directory of the target
+// container with the ID on the ephemeral container.
+func findTargetRoot(ctx context.Context, e Executor, id string) (string, error) {
+	output, err := e.Run(ctx, []string{"sh", "-c", findTargetPIDScript, "sh", id})
+	if err != nil {
+		return "", fmt.Errorf("unable to find a process of the target container: %w", err)
+	}
+	pid := strings.TrimSpace(string(output))
+	return "/proc/" + pid + "/root", nil
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestContainerID(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx"},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "nginx", ContainerID: "containerd://0123abcd"},
+				{Name: "sidecar", ContainerID: "cri-o://4567ef01"},
+				{Name: "waiting"},
+			},
+		},
+	}
+	for name, want := range map[string]string{"nginx": "0123abcd", "sidecar": "4567ef01"} {
+		id, err := containerID(pod, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != want {
+			t.Errorf("%s: id = %q, want %q", name, id, want)
+		}
+	}
+	for _, name := range []string{"waiting", "missing"} {
+		if _, err := containerID(pod, name); err == nil {
+			t.Errorf("%s: containerID succeeded", name)
+		}
+	}
+}
+
+// TestFindTargetRoot finds the process of the test by the last component of
+// its cgroup, like the ID of the container.
+func TestFindTargetRoot(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the script requires /proc")
+	}
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if base := path.Base(line[strings.LastIndex(line, ":")+1:]); len(base) > len(id) && base != "/" {
+			id = base
+		}
+	}
+	if id == "" {
+		t.Skip("the test process is in the root cgroup")
+	}
+
+	e := &LocalExecutor{}
+	root, err := findTargetRoot(context.Background(), e, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cgroup, err := os.ReadFile(filepath.Join(filepath.Dir(root), "cgroup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(cgroup), id) {
+		t.Errorf("%s is not in the cgroup %s: %s", root, id, cgroup)
+	}
+
+	if _, err := findTargetRoot(context.Background(), e, "no-such-container"); err == nil {
+		t.Error("findTargetRoot of a missing container succeeded")
+	}
+}
@@ -6,11 +6,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
+	"sync/atomic"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
 	"k8s.io/client-go/kubernetes/scheme"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/transport/spdy"
 	"k8s.io/client-go/util/exec"
 )
 
@@ -26,8 +31,16 @@ func (e *RemoteCommandErr) Error() string {
 type Executor interface {
 	Run(ctx context.Context, command []string) ([]byte, error)
 	RunRead(ctx context.Context, command []string) (io.ReadCloser, error)
+	RunWrite(ctx context.Context, command []string, stdin io.Reader) error
+	RunStream(ctx context.Context, command []string, stdin io.Reader, stdout io.Writer) error
 }
 
+const (
+	execProtocolAuto      = "auto"
+	execProtocolWebSocket = "websocket"
+	execProtocolSPDY      = "spdy"
+)
+
 type PodExecutor struct {
 	Namespace     string
 	PodName       string
@@ -35,11 +48,21 @@ type PodExecutor struct {
 
 	Config     *restclient.Config
 	RestClient *restclient.RESTClient
+
+	// Protocol is the protocol to run commands.  The auto protocol, or
+	// empty, tries WebSocket first, and falls back to SPDY if the server
+	// does not support WebSocket.
+	Protocol string
+
+	// spdyFallback is set once the server rejects WebSocket with the auto
+	// protocol, so that later commands use SPDY without trying WebSocket.
+	spdyFallback int32
 }
 
-func (e *PodExecutor) Run(ctx context.Context, command []string) ([]byte, error) {
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
+// stream runs the command, and returns RemoteCommandErr with the stderr if
+// the command exits with non-zero code.  The stdin of the command is closed
+// if stdin is nil.
+func (e *PodExecutor) stream(ctx context.Context, command []string, stdin io.Reader, stdout io.Writer) error {
 	req := e.RestClient.Post().
 		Resource("pods").
 		Name(e.PodName).
@@ -48,98 +71,132 @@ func (e *PodExecutor) Run(ctx context.Context, command []string) ([]byte, error)
 	req.VersionedParams(&corev1.PodExecOptions{
 		Container: e.ContainerName,
 		Command:   command,
-		Stdin:     false,
+		Stdin:     stdin != nil,
 		Stdout:    true,
 		Stderr:    true,
 		TTY:       false,
 	}, scheme.ParameterCodec)
 
-	executor, err := remotecommand.NewSPDYExecutor(e.Config, "POST", req.URL())
-	if err != nil {
-		return nil, err
-	}
-	err = executor.Stream(remotecommand.StreamOptions{
-		Stdout: &stdout,
-		Stderr: &stderr,
-		Tty:    false,
-	})
+	var stderr bytes.Buffer
+	err := e.streamURL(ctx, req.URL(), stdin, stdout, &stderr)
 	var execerr exec.CodeExitError
 	if errors.As(err, &execerr) {
-		return nil, &RemoteCommandErr{
+		return &RemoteCommandErr{
 			Stderr: stderr.Bytes(),
 			Err:    err,
 		}
 	}
-	if err != nil {
-		return nil, err
-	}
-	return stdout.Bytes(), nil
+	return err
 }
 
-type bufferCloser struct {
-	bytes.Buffer
-	closed bool
-	ch     chan struct{}
-}
-
-func (b *bufferCloser) Done() <-chan struct{} {
-	b.ch = make(chan struct{})
-	return b.ch
-}
+func (e *PodExecutor) streamURL(ctx context.Context, u *url.URL, stdin io.Reader, stdout, stderr io.Writer) error {
+	switch e.Protocol {
+	case execProtocolWebSocket:
+		return streamWebSocket(ctx, e.Config, u, stdin, stdout, stderr)
+	case execProtocolSPDY:
+	default:
+		if atomic.LoadInt32(&e.spdyFallback) == 0 {
+			err := streamWebSocket(ctx, e.Config, u, stdin, stdout, stderr)
+			if !isUpgradeError(err) {
+				return err
+			}
+			atomic.StoreInt32(&e.spdyFallback, 1)
+		}
+	}
 
-func (b *bufferCloser) Close() error {
-	if b.closed {
-		return nil
+	transport, upgrader, err := spdy.RoundTripperFor(e.Config)
+	if err != nil {
+		return err
+	}
+	executor, err := remotecommand.NewSPDYExecutorForTransports(transport, &cancelUpgrader{Upgrader: upgrader, ctx: ctx}, "POST", u)
+	if err != nil {
+		return err
+	}
+	err = executor.Stream(remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: &cancelWriter{Writer: stdout, ctx: ctx},
+		Stderr: stderr,
+		Tty:    false,
+	})
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
-	b.closed = true
-	b.ch <- struct{}{}
-	return nil
+	return err
 }
 
-func (e *PodExecutor) RunRead(ctx context.Context, command []string) (io.ReadCloser, error) {
-	req := e.RestClient.Post().
-		Resource("pods").
-		Name(e.PodName).
-		Namespace(e.Namespace).
-		SubResource("exec")
-	req.VersionedParams(&corev1.PodExecOptions{
-		Container: e.ContainerName,
-		Command:   command,
-		Stdin:     true,
-		Stdout:    true,
-		Stderr:    true,
-		TTY:       false,
-	}, scheme.ParameterCodec)
+// cancelUpgrader closes the SPDY connection when the context is done, since
+// the executor of SPDY cannot be canceled.
+type cancelUpgrader struct {
+	spdy.Upgrader
+	ctx context.Context
+}
 
-	executor, err := remotecommand.NewSPDYExecutor(e.Config, "POST", req.URL())
+func (u *cancelUpgrader) NewConnection(resp *http.Response) (httpstream.Connection, error) {
+	conn, err := u.Upgrader.NewConnection(resp)
 	if err != nil {
 		return nil, err
 	}
-	var stdout bufferCloser
-	var stderr bytes.Buffer
-	var stdin bytes.Buffer
-	err = executor.Stream(remotecommand.StreamOptions{
-		Stdout: &stdout,
-		Stderr: &stderr,
-		Stdin:  &stdin,
-		Tty:    false,
-	})
-	var execerr exec.CodeExitError
-	if errors.As(err, &execerr) {
-		return nil, &RemoteCommandErr{
-			Stderr: stderr.Bytes(),
-			Err:    err,
+	go func() {
+		select {
+		case <-u.ctx.Done():
+			conn.Close()
+		case <-conn.CloseChan():
 		}
+	}()
+	return conn, nil
+}
+
+// cancelWriter discards the output after the context is done.  The executor
+// of SPDY logs errors of writing the output, which are expected when the
+// reader of the output is closed.
+type cancelWriter struct {
+	io.Writer
+	ctx context.Context
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	if err != nil && w.ctx.Err() != nil {
+		return len(p), nil
 	}
-	if err != nil {
+	return n, err
+}
+
+func (e *PodExecutor) Run(ctx context.Context, command []string) ([]byte, error) {
+	var stdout bytes.Buffer
+	if err := e.stream(ctx, command, nil, &stdout); err != nil {
 		return nil, err
 	}
+	return stdout.Bytes(), nil
+}
+
+// streamReader is a reader of the stdout on the remote command.  Closing the
+// reader cancels the remote command, so the rest of the output is not
+// transferred.
+type streamReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (r *streamReader) Close() error {
+	r.cancel()
+	return r.PipeReader.CloseWithError(context.Canceled)
+}
 
+func (e *PodExecutor) RunRead(ctx context.Context, command []string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	r, w := io.Pipe()
 	go func() {
-		<-stdout.Done()
-		stdin.Write([]byte{0x03}) // Ctrl-C
-		io.Copy(io.Discard, &stdout)
+		defer cancel()
+		w.CloseWithError(e.stream(ctx, command, nil, w))
 	}()
+	return &streamReader{PipeReader: r, cancel: cancel}, nil
+}
+
+func (e *PodExecutor) RunWrite(ctx context.Context, command []string, stdin io.Reader) error {
+	return e.stream(ctx, command, stdin, io.Discard)
+}
 
-	return &stdout, nil
+func (e *PodExecutor) RunStream(ctx context.Context, command []string, stdin io.Reader, stdout io.Writer) error {
+	return e.stream(ctx, command, stdin, stdout)
 }
@@ -0,0 +1,436 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
+	"k8s.io/apimachinery/pkg/util/remotecommand"
+	restclient "k8s.io/client-go/rest"
+)
+
+const testToken = "test-token"
+
+// execServer is a stand-in of the API server, serving the exec subresource
+// of pods by running the commands on the local machine.  It supports SPDY,
+// and WebSocket if webSocket is true.
+type execServer struct {
+	webSocket bool
+
+	mu        sync.Mutex
+	protocols []string
+	rejected  int
+}
+
+func (s *execServer) record(protocol string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.protocols = append(s.protocols, protocol)
+}
+
+func (s *execServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Authorization") != "Bearer "+testToken {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !strings.HasSuffix(req.URL.Path, "/namespaces/default/pods/test/exec") {
+		http.NotFound(w, req)
+		return
+	}
+	query := req.URL.Query()
+	if query.Get("container") != "main" {
+		http.Error(w, "container not found", http.StatusBadRequest)
+		return
+	}
+	command := exec.Command(query["command"][0], query["command"][1:]...)
+	stdin := query.Get("stdin") == "true"
+
+	switch strings.ToLower(req.Header.Get("Upgrade")) {
+	case "websocket":
+		if !s.webSocket {
+			s.mu.Lock()
+			s.rejected++
+			s.mu.Unlock()
+			http.Error(w, "websocket is not supported", http.StatusBadRequest)
+			return
+		}
+		s.record(execProtocolWebSocket)
+		server := websocket.Server{
+			Handshake: func(config *websocket.Config, req *http.Request) error {
+				for _, p := range config.Protocol {
+					if p == streamProtocolV5Name {
+						config.Protocol = []string{p}
+						return nil
+					}
+				}
+				return websocket.ErrBadWebSocketProtocol
+			},
+			Handler: func(ws *websocket.Conn) {
+				s.serveWebSocket(ws, command, stdin)
+			},
+		}
+		server.ServeHTTP(w, req)
+	case "spdy/3.1":
+		s.record(execProtocolSPDY)
+		s.serveSPDY(w, req, command, stdin)
+	default:
+		http.Error(w, "upgrade request required", http.StatusBadRequest)
+	}
+}
+
+// channelWriter writes the data to the channel of the connection.
+type channelWriter struct {
+	ws      *websocket.Conn
+	channel byte
+}
+
+func (w *channelWriter) Write(p []byte) (int, error) {
+	if err := websocket.Message.Send(w.ws, append([]byte{w.channel}, p...)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *execServer) serveWebSocket(ws *websocket.Conn, command *exec.Cmd, stdin bool) {
+	defer ws.Close()
+	// The API server sends an empty message to each channel first
+	for _, c := range []byte{streamStdout, streamStderr, streamError} {
+		_ = websocket.Message.Send(ws, []byte{c})
+	}
+
+	var stdinw io.WriteCloser
+	if stdin {
+		var err error
+		stdinw, err = command.StdinPipe()
+		if err != nil {
+			panic(err)
+		}
+		go func() {
+			defer stdinw.Close()
+			for {
+				var data []byte
+				if err := websocket.Message.Receive(ws, &data); err != nil {
+					return
+				}
+				if len(data) == 2 && data[0] == streamClose && data[1] == streamStdin {
+					return
+				}
+				if len(data) > 0 && data[0] == streamStdin {
+					_, _ = stdinw.Write(data[1:])
+				}
+			}
+		}()
+	}
+	command.Stdout = &channelWriter{ws, streamStdout}
+	command.Stderr = &channelWriter{ws, streamStderr}
+	status := runStatus(command)
+	_ = websocket.Message.Send(ws, append([]byte{streamError}, status...))
+}
+
+func (s *execServer) serveSPDY(w http.ResponseWriter, req *http.Request, command *exec.Cmd, stdin bool) {
+	if _, err := httpstream.Handshake(req, w, []string{remotecommand.StreamProtocolV4Name}); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	streamCh := make(chan httpstream.Stream)
+	conn := spdy.NewResponseUpgrader().UpgradeResponse(w, req, func(stream httpstream.Stream, replySent <-chan struct{}) error {
+		streamCh <- stream
+		return nil
+	})
+	if conn == nil {
+		return
+	}
+	defer conn.Close()
+
+	expected := 3
+	if stdin {
+		expected++
+	}
+	streams := map[string]httpstream.Stream{}
+	for len(streams) < expected {
+		stream := <-streamCh
+		streams[stream.Headers().Get(corev1.StreamType)] = stream
+	}
+	if stdin {
+		command.Stdin = streams[corev1.StreamTypeStdin]
+	}
+	command.Stdout = streams[corev1.StreamTypeStdout]
+	command.Stderr = streams[corev1.StreamTypeStderr]
+	status := runStatus(command)
+	_, _ = streams[corev1.StreamTypeError].Write(status)
+	for _, stream := range streams {
+		stream.Close()
+	}
+}
+
+// runStatus runs the command, and returns the status in the error channel
+// with the exit code.
+func runStatus(command *exec.Cmd) []byte {
+	status := metav1.Status{Status: metav1.StatusSuccess}
+	if err := command.Run(); err != nil {
+		status = metav1.Status{Status: metav1.StatusFailure, Message: err.Error()}
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			status.Reason = remotecommand.NonZeroExitCodeReason
+			status.Details = &metav1.StatusDetails{
+				Causes: []metav1.StatusCause{{
+					Type:    remotecommand.ExitCodeCauseType,
+					Message: strconv.Itoa(exitErr.ExitCode()),
+				}},
+			}
+		}
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// newTestPodExecutor returns an executor of the pod on the server.
+func newTestPodExecutor(t *testing.T, s *execServer, protocol string) *PodExecutor {
+	t.Helper()
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	config := &restclient.Config{Host: srv.URL, BearerToken: testToken}
+	if err := setKubernetesDefaults(config); err != nil {
+		t.Fatal(err)
+	}
+	restClient, err := restclient.RESTClientFor(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &PodExecutor{
+		Namespace:     "default",
+		PodName:       "test",
+		ContainerName: "main",
+		Config:        config,
+		RestClient:    restClient,
+		Protocol:      protocol,
+	}
+}
+
+func TestPodExecutor(t *testing.T) {
+	tests := []struct {
+		name      string
+		protocol  string
+		webSocket bool
+		want      string
+		rejected  int
+	}{
+		{"auto", execProtocolAuto, true, execProtocolWebSocket, 0},
+		{"auto/fallback", execProtocolAuto, false, execProtocolSPDY, 1},
+		{"websocket", execProtocolWebSocket, true, execProtocolWebSocket, 0},
+		{"spdy", execProtocolSPDY, true, execProtocolSPDY, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &execServer{webSocket: tt.webSocket}
+			e := newTestPodExecutor(t, s, tt.protocol)
+			ctx := context.Background()
+			dir := t.TempDir()
+
+			output, err := e.Run(ctx, []string{"sh", "-c", "echo out; echo err >&2"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(output) != "out\n" {
+				t.Errorf("output = %q, want %q", output, "out\n")
+			}
+
+			_, err = e.Run(ctx, []string{"cat", filepath.Join(dir, "missing")})
+			if !os.IsNotExist(toOSError(err)) {
+				t.Errorf("err = %v, want not exist", err)
+			}
+
+			// The remote command sees the end of the stdin
+			data := bytes.Repeat([]byte("0123456789"), 10000)
+			p := filepath.Join(dir, "file")
+			if err := e.RunWrite(ctx, []string{"sh", "-c", `cat >"$0"`, p}, bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+			if written, err := os.ReadFile(p); err != nil || !bytes.Equal(written, data) {
+				t.Errorf("written %d bytes, %v, want %d bytes", len(written), err, len(data))
+			}
+
+			r, err := e.RunRead(ctx, []string{"cat", p})
+			if err != nil {
+				t.Fatal(err)
+			}
+			read, err := io.ReadAll(r)
+			r.Close()
+			if err != nil || !bytes.Equal(read, data) {
+				t.Errorf("read %d bytes, %v, want %d bytes", len(read), err, len(data))
+			}
+
+			var stdout bytes.Buffer
+			if err := e.RunStream(ctx, []string{"tr", "a-z", "A-Z"}, strings.NewReader("stream"), &stdout); err != nil {
+				t.Fatal(err)
+			}
+			if stdout.String() != "STREAM" {
+				t.Errorf("stdout = %q, want %q", stdout.String(), "STREAM")
+			}
+
+			// Closing the reader early stops the remote command, and
+			// the command continues after the output is broken
+			done := filepath.Join(dir, "done")
+			r, err = e.RunRead(ctx, []string{"sh", "-c", `yes; touch "$0"`, done})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadFull(r, make([]byte, 10)); err != nil {
+				t.Fatal(err)
+			}
+			r.Close()
+			for i := 0; ; i++ {
+				if _, err := os.Stat(done); err == nil {
+					break
+				}
+				if i == 100 {
+					t.Fatal("the remote command is still running after the reader is closed")
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+
+			// WebSocket is not tried again after falling back to SPDY
+			want := []string{tt.want, tt.want, tt.want, tt.want, tt.want, tt.want}
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if strings.Join(s.protocols, ",") != strings.Join(want, ",") {
+				t.Errorf("protocols = %v, want %v", s.protocols, want)
+			}
+			if s.rejected != tt.rejected {
+				t.Errorf("rejected %d WebSocket requests, want %d", s.rejected, tt.rejected)
+			}
+		})
+	}
+}
+
+func TestPodExecutorErrors(t *testing.T) {
+	s := &execServer{webSocket: false}
+	e := newTestPodExecutor(t, s, execProtocolWebSocket)
+	_, err := e.Run(context.Background(), []string{"true"})
+	if !isUpgradeError(err) {
+		t.Errorf("err = %v, want an upgrade error", err)
+	}
+
+	// Authentication errors do not fall back to SPDY
+	for _, protocol := range []string{execProtocolAuto, execProtocolWebSocket} {
+		s = &execServer{webSocket: true}
+		e = newTestPodExecutor(t, s, protocol)
+		e.Config.BearerToken = "invalid"
+		_, err = e.Run(context.Background(), []string{"true"})
+		if err == nil || isUpgradeError(err) || !strings.Contains(err.Error(), "Unauthorized") {
+			t.Errorf("%s: err = %v, want an unauthorized error", protocol, err)
+		}
+		if e.spdyFallback != 0 || len(s.protocols) != 0 {
+			t.Errorf("%s: fell back to SPDY on the unauthorized error", protocol)
+		}
+	}
+
+	e = newTestPodExecutor(t, s, execProtocolWebSocket)
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error)
+	go func() {
+		_, err := e.Run(ctx, []string{"sleep", "3"})
+		errCh <- err
+	}()
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if err := <-errCh; err != context.Canceled {
+		t.Errorf("err = %v, want %v", err, context.Canceled)
+	}
+}
+
+// connectProxy is an HTTP proxy tunneling connections by CONNECT.
+type connectProxy struct {
+	mu      sync.Mutex
+	targets []string
+	auth    []string
+}
+
+func (p *connectProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodConnect {
+		http.Error(w, "CONNECT is required", http.StatusMethodNotAllowed)
+		return
+	}
+	p.mu.Lock()
+	p.targets = append(p.targets, req.Host)
+	p.auth = append(p.auth, req.Header.Get("Proxy-Authorization"))
+	p.mu.Unlock()
+
+	target, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer target.Close()
+	conn, buf, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		return
+	}
+	go func() {
+		_, _ = io.Copy(target, buf)
+		target.Close()
+	}()
+	_, _ = io.Copy(conn, target)
+}
+
+func TestPodExecutorProxy(t *testing.T) {
+	proxy := &connectProxy{}
+	proxySrv := httptest.NewServer(proxy)
+	t.Cleanup(proxySrv.Close)
+	proxyURL, err := url.Parse(proxySrv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyURL.User = url.UserPassword("user", "secret")
+
+	s := &execServer{webSocket: true}
+	e := newTestPodExecutor(t, s, execProtocolAuto)
+	e.Config.Proxy = http.ProxyURL(proxyURL)
+	output, err := e.Run(context.Background(), []string{"echo", "ok"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "ok\n" {
+		t.Errorf("output = %q, want %q", output, "ok\n")
+	}
+
+	host := strings.TrimPrefix(e.Config.Host, "http://")
+	proxy.mu.Lock()
+	defer proxy.mu.Unlock()
+	if len(proxy.targets) != 1 || proxy.targets[0] != host {
+		t.Errorf("CONNECT to %v, want %s", proxy.targets, host)
+	}
+	if want := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret")); len(proxy.auth) != 1 || proxy.auth[0] != want {
+		t.Errorf("Proxy-Authorization = %v, want %q", proxy.auth, want)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.protocols) != 1 || s.protocols[0] != execProtocolWebSocket {
+		t.Errorf("protocols = %v, want [%s]", s.protocols, execProtocolWebSocket)
+	}
+}
@@ -0,0 +1,541 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// fakeExecutor is an Executor which interprets the commands issued by PodFS
+// against a file system, such as fstest.MapFS or a local directory by
+// localDirFS, instead of running them in a container.  Absolute paths in
+// the commands are relative to the root of the file system.
+//
+// It implements find, stat, ls, cat, dd, readlink and df in the GNU flavor
+// with the options used by PodFS, and the shell scripts to probe the
+// capabilities and to list directories.  The file system is read-only, so
+// commands to modify files fail with "Read-only file system".
+type fakeExecutor struct {
+	fsys fs.FS
+
+	mu sync.Mutex
+	// commands is the commands run by the executor.
+	commands [][]string
+}
+
+func newFakeExecutor(fsys fs.FS) *fakeExecutor {
+	return &fakeExecutor{fsys: fsys}
+}
+
+func (e *fakeExecutor) record(command []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.commands = append(e.commands, command)
+}
+
+// localDirFS is a file system of the local directory like os.DirFS, which
+// also reads symlinks without following them.  Unlike os.DirFS, names are
+// not required to be valid UTF-8.
+type localDirFS string
+
+func (d localDirFS) Open(name string) (fs.File, error) {
+	return os.Open(filepath.Join(string(d), filepath.FromSlash(name)))
+}
+
+func (d localDirFS) Lstat(name string) (fs.FileInfo, error) {
+	return os.Lstat(filepath.Join(string(d), filepath.FromSlash(name)))
+}
+
+func (d localDirFS) ReadLink(name string) (string, error) {
+	return os.Readlink(filepath.Join(string(d), filepath.FromSlash(name)))
+}
+
+// fakeCommandErr returns an error of the command failed by err, with the
+// message of the GNU coreutils in stderr.
+func fakeCommandErr(command, p string, err error) error {
+	message := err.Error()
+	var errno syscall.Errno
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		message = "No such file or directory"
+	case errors.As(err, &errno):
+		message = map[syscall.Errno]string{
+			syscall.EACCES:  "Permission denied",
+			syscall.EINVAL:  "Invalid argument",
+			syscall.EISDIR:  "Is a directory",
+			syscall.ENOTDIR: "Not a directory",
+			syscall.EROFS:   "Read-only file system",
+		}[errno]
+	}
+	return &RemoteCommandErr{
+		Stderr: []byte(fmt.Sprintf("%s: %s: %s\n", command, p, message)),
+		Err:    errors.New("command terminated with exit code 1"),
+	}
+}
+
+// name returns the name on the file system of the absolute path.
+func (e *fakeExecutor) name(p string) string {
+	name := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// lstat returns the file information of the path without following a
+// symlink, unless the path ends with "/.".
+func (e *fakeExecutor) lstat(p string) (fs.FileInfo, error) {
+	name := e.name(p)
+	if fsys, ok := e.fsys.(interface {
+		Lstat(name string) (fs.FileInfo, error)
+	}); ok && !strings.HasSuffix(p, "/.") {
+		return fsys.Lstat(name)
+	}
+	return fs.Stat(e.fsys, name)
+}
+
+func (e *fakeExecutor) readlink(p string) (string, error) {
+	name := e.name(p)
+	if fsys, ok := e.fsys.(interface {
+		ReadLink(name string) (string, error)
+	}); ok {
+		return fsys.ReadLink(name)
+	}
+	// fstest.MapFS before Go 1.25 does not follow symlinks, and the data
+	// of the symlink is the target
+	inf, err := fs.Stat(e.fsys, name)
+	if err != nil {
+		return "", err
+	}
+	if inf.Mode()&fs.ModeSymlink == 0 {
+		return "", syscall.EINVAL
+	}
+	data, err := fs.ReadFile(e.fsys, name)
+	return string(data), err
+}
+
+func (e *fakeExecutor) readFile(p string) ([]byte, error) {
+	inf, err := fs.Stat(e.fsys, e.name(p))
+	if err != nil {
+		return nil, err
+	}
+	if inf.IsDir() {
+		return nil, syscall.EISDIR
+	}
+	return fs.ReadFile(e.fsys, e.name(p))
+}
+
+func (e *fakeExecutor) Run(ctx context.Context, command []string) ([]byte, error) {
+	e.record(command)
+	args := command[1:]
+	switch command[0] {
+	case "sh":
+		if len(args) >= 3 && args[0] == "-c" {
+			return e.runScript(args[1], args[3:])
+		}
+	case "find":
+		return e.find(args)
+	case "stat":
+		return e.stat(args)
+	case "ls":
+		return e.ls(args)
+	case "cat":
+		var out []byte
+		for _, p := range operands(args) {
+			data, err := e.readFile(p)
+			if err != nil {
+				return nil, fakeCommandErr("cat", p, err)
+			}
+			out = append(out, data...)
+		}
+		return out, nil
+	case "dd":
+		return e.dd(args)
+	case "readlink":
+		p := operands(args)[0]
+		target, err := e.readlink(p)
+		if err != nil {
+			return nil, &RemoteCommandErr{Err: err}
+		}
+		return []byte(target + "\n"), nil
+	case "df":
+		p := operands(args)[0]
+		if _, err := e.lstat(p); err != nil {
+			return nil, fakeCommandErr("df", p, err)
+		}
+		return []byte("Filesystem     1024-blocks  Used Available Capacity Mounted on\n" +
+			"fakefs                4000  2000      1600      56% /\n"), nil
+	case "mkdir", "rm", "rmdir", "mv", "ln", "chmod", "chown", "truncate", "touch":
+		p := operands(args)[len(operands(args))-1]
+		return nil, fakeCommandErr(command[0], p, syscall.EROFS)
+	}
+	return nil, &RemoteCommandErr{
+		Stderr: []byte(fmt.Sprintf("sh: %s: not found\n", command[0])),
+		Err:    errors.New("command terminated with exit code 127"),
+	}
+}
+
+func (e *fakeExecutor) RunRead(ctx context.Context, command []string) (io.ReadCloser, error) {
+	output, err := e.Run(ctx, command)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(output)), nil
+}
+
+func (e *fakeExecutor) RunWrite(ctx context.Context, command []string, stdin io.Reader) error {
+	e.record(command)
+	return fakeCommandErr(command[0], "/", syscall.EROFS)
+}
+
+func (e *fakeExecutor) RunStream(ctx context.Context, command []string, stdin io.Reader, stdout io.Writer) error {
+	e.record(command)
+	return fakeCommandErr(command[0], "/", syscall.ENOTSUP)
+}
+
+// operands returns the arguments except the options before "--".
+func operands(args []string) []string {
+	for i, arg := range args {
+		if arg == "--" {
+			return args[i+1:]
+		}
+	}
+	var ops []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			ops = append(ops, arg)
+		}
+	}
+	return ops
+}
+
+// runScript runs the known scripts of PodFS with the positional arguments.
+func (e *fakeExecutor) runScript(script string, args []string) ([]byte, error) {
+	switch script {
+	case probeScript:
+		var out bytes.Buffer
+		for _, c := range args {
+			switch c {
+			case "find", "stat", "ls", "cat", "dd", "df", "readlink":
+				fmt.Fprintf(&out, "command %s\n", c)
+			}
+		}
+		out.WriteString("stat-printf\nstat-format\nuserland gnu\n")
+		return out.Bytes(), nil
+	case readDirScript:
+		return e.readDirLoop(args[0], args[1:])
+	}
+	return nil, &RemoteCommandErr{
+		Stderr: []byte("sh: unknown script\n"),
+		Err:    errors.New("command terminated with exit code 2"),
+	}
+}
+
+// entries returns the path of the directory and the paths of its entries.
+func (e *fakeExecutor) entries(dir string) ([]string, error) {
+	des, err := fs.ReadDir(e.fsys, e.name(dir))
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{dir}
+	for _, de := range des {
+		paths = append(paths, strings.TrimSuffix(dir, "/")+"/"+de.Name())
+	}
+	return paths, nil
+}
+
+// readDirLoop runs the command for the directory and each entry in it, like
+// readDirScript.
+func (e *fakeExecutor) readDirLoop(dir string, command []string) ([]byte, error) {
+	paths, err := e.entries(dir)
+	if err != nil {
+		return nil, fakeCommandErr("cd", dir, err)
+	}
+	var out bytes.Buffer
+	for i, p := range paths {
+		name := "."
+		if i > 0 {
+			name = path.Base(p)
+		}
+		s, err := e.Run(context.TODO(), append(append([]string{}, command...), p))
+		if err != nil {
+			continue
+		}
+		// The command substitution trims the trailing new-lines
+		fmt.Fprintf(&out, "%s\x00%s\x00", name, bytes.TrimRight(s, "\n"))
+	}
+	return out.Bytes(), nil
+}
+
+// find runs "find DIR -maxdepth 1 -exec COMMAND... {} +".
+func (e *fakeExecutor) find(args []string) ([]byte, error) {
+	if len(args) < 6 || args[1] != "-maxdepth" || args[2] != "1" || args[3] != "-exec" ||
+		args[len(args)-2] != "{}" || args[len(args)-1] != "+" {
+		return nil, fmt.Errorf("unsupported find: %q", args)
+	}
+	paths, err := e.entries(args[0])
+	if err != nil {
+		return nil, fakeCommandErr("find", args[0], err)
+	}
+	command := append(append([]string{}, args[4:len(args)-2]...), paths...)
+	return e.Run(context.TODO(), command)
+}
+
+// fakeStat is the attributes of a file reported by the fake commands.
+type fakeStat struct {
+	LinuxStat_t
+	mtime time.Time
+}
+
+func (e *fakeExecutor) fileStat(p string) (*fakeStat, error) {
+	inf, err := e.lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	st := &fakeStat{mtime: inf.ModTime()}
+	switch sys := inf.Sys().(type) {
+	case *LinuxStat_t:
+		// Attributes given by the test such as fstest.MapFile.Sys
+		st.LinuxStat_t = *sys
+	case *syscall.Stat_t:
+		st.Ino = uint64(sys.Ino)
+		st.Nlink = uint64(sys.Nlink)
+		st.Uid = sys.Uid
+		st.Gid = sys.Gid
+		st.Rdev = uint64(sys.Rdev)
+		st.Dev = uint64(sys.Dev)
+	}
+	if st.Ino == 0 {
+		h := fnv.New64a()
+		h.Write([]byte(e.name(p)))
+		st.Ino = h.Sum64()
+	}
+	if st.Nlink == 0 {
+		st.Nlink = 1
+	}
+	st.Mode = fromFileMode(inf.Mode())
+	st.Size = inf.Size()
+	st.Blksize = 4096
+	st.Blocks = (st.Size + 511) / 512
+	return st, nil
+}
+
+// fromFileMode converts fs.FileMode to st_mode on linux.
+func fromFileMode(mode fs.FileMode) uint32 {
+	rawmode := uint32(mode.Perm())
+	switch {
+	case mode&fs.ModeDir != 0:
+		rawmode |= S_IFDIR
+	case mode&fs.ModeSymlink != 0:
+		rawmode |= S_IFLNK
+	case mode&fs.ModeNamedPipe != 0:
+		rawmode |= S_IFIFO
+	case mode&fs.ModeSocket != 0:
+		rawmode |= S_IFSOCK
+	case mode&fs.ModeCharDevice != 0:
+		rawmode |= S_IFCHR
+	case mode&fs.ModeDevice != 0:
+		rawmode |= S_IFBLK
+	default:
+		rawmode |= S_IFREG
+	}
+	if mode&fs.ModeSetuid != 0 {
+		rawmode |= syscall.S_ISUID
+	}
+	if mode&fs.ModeSetgid != 0 {
+		rawmode |= syscall.S_ISGID
+	}
+	if mode&fs.ModeSticky != 0 {
+		rawmode |= syscall.S_ISVTX
+	}
+	return rawmode
+}
+
+// major and minor return the device numbers of the device encoded by mkdev.
+func major(dev uint64) uint64 { return (dev>>8)&0xfff | (dev>>32)&^0xfff }
+func minor(dev uint64) uint64 { return dev&0xff | (dev>>12)&^0xff }
+
+// stat runs "stat -c FORMAT", "stat --printf FORMAT" or "stat -f -c FORMAT".
+func (e *fakeExecutor) stat(args []string) ([]byte, error) {
+	var format string
+	var printf, statfs bool
+	for i := 0; i < len(args) && args[i] != "--"; i++ {
+		switch args[i] {
+		case "-c":
+			format = args[i+1]
+		case "--printf":
+			format, printf = args[i+1], true
+		case "-f":
+			statfs = true
+		}
+	}
+	if printf {
+		format = strings.NewReplacer(`\0`, "\x00", `\t`, "\t", `\n`, "\n").Replace(format)
+	} else {
+		format += "\n"
+	}
+
+	var out bytes.Buffer
+	for _, p := range operands(args) {
+		st, err := e.fileStat(p)
+		if err != nil {
+			return nil, fakeCommandErr("stat", p, err)
+		}
+		if statfs {
+			out.WriteString(statfsFormatOf(format))
+		} else {
+			out.WriteString(statFormatOf(format, p, st))
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// statFormatOf formats the attributes of the file like GNU stat.
+func statFormatOf(format, p string, st *fakeStat) string {
+	human := func(t time.Time) string {
+		return t.UTC().Format("2006-01-02 15:04:05.000000000 -0700")
+	}
+	values := map[byte]string{
+		'n': p,
+		'i': strconv.FormatUint(st.Ino, 10),
+		's': strconv.FormatInt(st.Size, 10),
+		'B': "512",
+		'b': strconv.FormatInt(st.Blocks, 10),
+		'f': strconv.FormatUint(uint64(st.Mode), 16),
+		'a': strconv.FormatUint(uint64(st.Mode&07777), 8),
+		'X': strconv.FormatInt(st.mtime.Unix(), 10),
+		'Y': strconv.FormatInt(st.mtime.Unix(), 10),
+		'Z': strconv.FormatInt(st.mtime.Unix(), 10),
+		'x': human(st.mtime),
+		'y': human(st.mtime),
+		'z': human(st.mtime),
+		'u': strconv.FormatUint(uint64(st.Uid), 10),
+		'g': strconv.FormatUint(uint64(st.Gid), 10),
+		'h': strconv.FormatUint(st.Nlink, 10),
+		't': strconv.FormatUint(major(st.Rdev), 16),
+		'T': strconv.FormatUint(minor(st.Rdev), 16),
+		'd': strconv.FormatUint(st.Dev, 10),
+	}
+	return expandFormat(format, values)
+}
+
+// statfsFormatOf formats the statistics of the fake filesystem like GNU
+// stat -f.
+func statfsFormatOf(format string) string {
+	return expandFormat(format, map[byte]string{
+		'S': "4096", 's': "4096", 'b': "1000", 'f': "500", 'a': "400",
+		'c': "100", 'd': "50", 'l': "255",
+	})
+}
+
+func expandFormat(format string, values map[byte]string) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		if v, ok := values[format[i]]; ok {
+			b.WriteString(v)
+		} else {
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// ls runs "ls -dinl" or "ls -dl" in the GNU format with numeric IDs.
+func (e *fakeExecutor) ls(args []string) ([]byte, error) {
+	inode := len(args) > 0 && strings.Contains(args[0], "i")
+	var out bytes.Buffer
+	for _, p := range operands(args) {
+		st, err := e.fileStat(p)
+		if err != nil {
+			return nil, fakeCommandErr("ls", p, err)
+		}
+		if inode {
+			fmt.Fprintf(&out, "%d ", st.Ino)
+		}
+		size := strconv.FormatInt(st.Size, 10)
+		if ft := st.Mode & S_IFMT; ft == S_IFCHR || ft == S_IFBLK {
+			size = fmt.Sprintf("%d, %d", major(st.Rdev), minor(st.Rdev))
+		}
+		mtime := st.mtime.UTC().Format("Jan _2 15:04")
+		if time.Since(st.mtime) > 180*24*time.Hour || time.Until(st.mtime) > time.Hour {
+			mtime = st.mtime.UTC().Format("Jan _2  2006")
+		}
+		fmt.Fprintf(&out, "%s %d %d %d %s %s %s", lsMode(st.Mode), st.Nlink, st.Uid, st.Gid, size, mtime, p)
+		if st.Mode&S_IFMT == S_IFLNK {
+			target, err := e.readlink(p)
+			if err != nil {
+				return nil, fakeCommandErr("ls", p, err)
+			}
+			fmt.Fprintf(&out, " -> %s", target)
+		}
+		out.WriteByte('\n')
+	}
+	return out.Bytes(), nil
+}
+
+// lsMode returns the mode string of ls -l such as "drwxr-sr-t".
+func lsMode(rawmode uint32) string {
+	b := []byte("?---------")
+	for c, ft := range lsFileTypes {
+		if rawmode&S_IFMT == ft {
+			b[0] = c
+		}
+	}
+	for i := 0; i < 9; i++ {
+		if rawmode&(0400>>i) != 0 {
+			b[i+1] = "rwx"[i%3]
+		}
+		if special := specialBit(i); special != 0 && rawmode&special != 0 {
+			if b[i+1] == '-' {
+				b[i+1] = "SST"[i/3]
+			} else {
+				b[i+1] = "sst"[i/3]
+			}
+		}
+	}
+	return string(b)
+}
+
+// dd runs "dd if=FILE bs=N skip=N count=N".
+func (e *fakeExecutor) dd(args []string) ([]byte, error) {
+	operand := map[string]string{}
+	for _, arg := range args {
+		if i := strings.IndexByte(arg, '='); i >= 0 {
+			operand[arg[:i]] = arg[i+1:]
+		}
+	}
+	num := func(key string) int64 {
+		n, _ := strconv.ParseInt(operand[key], 10, 64)
+		return n
+	}
+	data, err := e.readFile(operand["if"])
+	if err != nil {
+		return nil, fakeCommandErr("dd", operand["if"], err)
+	}
+	bs := num("bs")
+	off, end := num("skip")*bs, (num("skip")+num("count"))*bs
+	if off > int64(len(data)) {
+		off = int64(len(data))
+	}
+	if end > int64(len(data)) {
+		end = int64(len(data))
+	}
+	return data[off:end], nil
+}
@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// replacementSelectors returns selectors of pods replacing the pod.  The
+// pods are selected by the workload found by following the controller
+// references, or by the name of the pod if the pod has no workload.
+func replacementSelectors(ctx context.Context, api kubernetes.Interface, pod *corev1.Pod) (metav1.ListOptions, error) {
+	owner, err := podWorkload(ctx, api, pod)
+	if err != nil {
+		return metav1.ListOptions{}, err
+	}
+	if owner == nil {
+		return metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("metadata.name", pod.Name).String(),
+		}, nil
+	}
+	selector, err := selectorForObject(owner)
+	if err != nil {
+		return metav1.ListOptions{}, err
+	}
+	return metav1.ListOptions{LabelSelector: selector.String()}, nil
+}
+
+// podWorkload returns the workload controlling the pod, or nil if the pod
+// is not controlled by any workload.  A ReplicaSet owned by a Deployment is
+// resolved to the Deployment, so the pods of the new ReplicaSet in a rollout
+// are also selected.
+func podWorkload(ctx context.Context, api kubernetes.Interface, pod *corev1.Pod) (runtime.Object, error) {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil {
+		return nil, nil
+	}
+	ns := pod.Namespace
+	opts := metav1.GetOptions{}
+	switch ref.Kind {
+	case "ReplicaSet":
+		rs, err := api.AppsV1().ReplicaSets(ns).Get(ctx, ref.Name, opts)
+		if err != nil {
+			return nil, err
+		}
+		if ref := metav1.GetControllerOf(rs); ref != nil && ref.Kind == "Deployment" {
+			return api.AppsV1().Deployments(ns).Get(ctx, ref.Name, opts)
+		}
+		return rs, nil
+	case "StatefulSet":
+		return api.AppsV1().StatefulSets(ns).Get(ctx, ref.Name, opts)
+	case "DaemonSet":
+		return api.AppsV1().DaemonSets(ns).Get(ctx, ref.Name, opts)
+	case "Job":
+		return api.BatchV1().Jobs(ns).Get(ctx, ref.Name, opts)
+	case "ReplicationController":
+		return api.CoreV1().ReplicationControllers(ns).Get(ctx, ref.Name, opts)
+	}
+	return nil, nil
+}
+
+// podFollower switches the filesystem to the replacement pod when the
+// mounted pod is deleted, and reconnects to the container when the container
+// is restarted.  Connecting to the container runs commands in the container,
+// so it runs without holding the lock, and the connection is discarded if a
+// newer connection is started meanwhile.
+type podFollower struct {
+	o     *MountOptions
+	ctx   context.Context
+	fsys  *SwitchFS
+	cache *CachedFS
+
+	mu  sync.Mutex
+	pod *corev1.Pod
+	// target is the pod being connected or mounted, and restarts is the
+	// restart count of the container in the target.
+	target   *corev1.Pod
+	restarts int32
+	// gen is incremented when a connection starts or the filesystem is
+	// detached.
+	gen     uint64
+	running map[types.UID]*corev1.Pod
+}
+
+func newPodFollower(ctx context.Context, o *MountOptions, fsys *SwitchFS, cache *CachedFS) *podFollower {
+	return &podFollower{
+		o:       o,
+		ctx:     ctx,
+		fsys:    fsys,
+		cache:   cache,
+		running: map[types.UID]*corev1.Pod{},
+	}
+}
+
+// Start connects to the container in the pod.
+func (f *podFollower) Start(pod *corev1.Pod) error {
+	f.mu.Lock()
+	gen := f.begin(pod)
+	f.mu.Unlock()
+	_, err := f.connect(pod, gen)
+	return err
+}
+
+// begin starts a connection to the pod, and returns the generation of the
+// connection.  It must be called with the lock held.
+func (f *podFollower) begin(pod *corev1.Pod) uint64 {
+	f.target = pod
+	f.restarts = restartCount(pod, f.o.containerFor(pod))
+	f.gen++
+	return f.gen
+}
+
+// connect connects to the container in the pod, and switches the filesystem
+// to the container unless a newer connection is started.  It reports whether
+// the filesystem is switched.
+func (f *podFollower) connect(pod *corev1.Pod, gen uint64) (bool, error) {
+	backend, release, err := f.o.newBackend(f.ctx, pod, f.o.containerFor(pod))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.gen != gen {
+		if err == nil {
+			release()
+		}
+		return false, nil
+	}
+	if err != nil {
+		f.detach()
+		return false, err
+	}
+	f.pod = pod
+	f.fsys.Switch(backend, release)
+	f.cache.Invalidate(".")
+	return true, nil
+}
+
+// detach switches the filesystem to no pods.  It must be called with the
+// lock held.
+func (f *podFollower) detach() {
+	f.pod = nil
+	f.target = nil
+	f.gen++
+	f.fsys.Switch(nil, nil)
+	f.cache.Invalidate(".")
+}
+
+// Current returns the pod and the name of the container currently mounted.
+// The pod is nil while no pods are running.
+func (f *podFollower) Current() (*corev1.Pod, string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.pod == nil {
+		return nil, ""
+	}
+	return f.pod, f.o.containerFor(f.pod)
+}
+
+func (f *podFollower) Handler() podHandler {
+	return podHandler{
+		Running: f.onRunning,
+		Stopped: f.onStopped,
+	}
+}
+
+func (f *podFollower) onRunning(pod *corev1.Pod) {
+	f.mu.Lock()
+	f.running[pod.UID] = pod
+
+	var reason string
+	switch {
+	case f.target == nil:
+		reason = fmt.Sprintf("Pod %s is running", pod.Name)
+	case f.target.UID == pod.UID:
+		containerName := f.o.containerFor(pod)
+		if restartCount(pod, containerName) > f.restarts {
+			reason = fmt.Sprintf("Container %s on pod %s is restarted", containerName, pod.Name)
+		}
+	}
+	if reason == "" {
+		f.mu.Unlock()
+		return
+	}
+	gen := f.begin(pod)
+	f.mu.Unlock()
+	f.follow(pod, gen, reason)
+}
+
+func (f *podFollower) onStopped(pod *corev1.Pod) {
+	f.mu.Lock()
+	delete(f.running, pod.UID)
+
+	if f.target == nil || f.target.UID != pod.UID {
+		f.mu.Unlock()
+		return
+	}
+	var candidates []corev1.Pod
+	for _, p := range f.running {
+		candidates = append(candidates, *p)
+	}
+	next := selectPod(candidates)
+	if next == nil {
+		fmt.Fprintf(os.Stderr, "Pod %s is stopped; waiting for a replacement pod\n", pod.Name)
+		f.detach()
+		f.mu.Unlock()
+		return
+	}
+	gen := f.begin(next)
+	f.mu.Unlock()
+	f.follow(next, gen, fmt.Sprintf("Pod %s is stopped", pod.Name))
+}
+
+// follow connects to the pod, and logs the switch with the reason.
+func (f *podFollower) follow(pod *corev1.Pod, gen uint64, reason string) {
+	switched, err := f.connect(pod, gen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s; unable to switch to pod %s: %v\n", reason, pod.Name, err)
+		return
+	}
+	if switched {
+		fmt.Fprintf(os.Stderr, "%s; switched to pod %s\n", reason, pod.Name)
+	}
+}
+
+// restartCount returns the restart count of the container in the pod.
+func restartCount(pod *corev1.Pod, containerName string) int32 {
+	if s := findContainerStatus(pod, containerName); s != nil {
+		return s.RestartCount
+	}
+	return 0
+}
@@ -1,10 +1,17 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"hash/fnv"
 	"io"
 	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"syscall"
 
 	fusefs "github.com/hanwen/go-fuse/v2/fs"
@@ -14,8 +21,27 @@ import (
 type PodFuseNode struct {
 	fusefs.Inode
 
-	file string
-	fsys fs.FS
+	file     string
+	fsys     fs.FS
+	writable bool
+
+	// xattrs enables reading extended attributes of the remote files
+	xattrs bool
+
+	// source returns the pod and the container of the file for the user.k8s.*
+	// extended attributes
+	source metaSource
+
+	// meta is the metadata directory shown at the root of the mount
+	meta *MetaDirNode
+
+	// watch watches changes of files on the pod for the root of the mount
+	watch *changeWatcher
+
+	// root identifies the root of the filesystem among roots in the mount,
+	// such as pods with --all-pods.  It is zero if the mount has a single
+	// root.
+	root uint64
 }
 
 var _ = (fusefs.NodeReaddirer)((*PodFuseNode)(nil))
@@ -31,6 +57,29 @@ var _ = (fusefs.NodeMkdirer)((*PodFuseNode)(nil))
 var _ = (fusefs.NodeLinker)((*PodFuseNode)(nil))
 var _ = (fusefs.NodeUnlinker)((*PodFuseNode)(nil))
 var _ = (fusefs.NodeSymlinker)((*PodFuseNode)(nil))
+var _ = (fusefs.NodeCreater)((*PodFuseNode)(nil))
+var _ = (fusefs.NodeWriter)((*PodFuseNode)(nil))
+var _ = (fusefs.NodeFlusher)((*PodFuseNode)(nil))
+var _ = (fusefs.NodeFsyncer)((*PodFuseNode)(nil))
+var _ = (fusefs.NodeRmdirer)((*PodFuseNode)(nil))
+var _ = (fusefs.NodeRenamer)((*PodFuseNode)(nil))
+var _ = (fusefs.NodeLseeker)((*PodFuseNode)(nil))
+var _ = (fusefs.NodeOnAdder)((*PodFuseNode)(nil))
+var _ = (fusefs.NodeGetxattrer)((*PodFuseNode)(nil))
+var _ = (fusefs.NodeListxattrer)((*PodFuseNode)(nil))
+var _ = (fusefs.NodeStatfser)((*PodFuseNode)(nil))
+
+// whence for lseek(2) to find data and holes in the file
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+func (n *PodFuseNode) OnAdd(ctx context.Context) {
+	if n.watch != nil {
+		n.watch.start(n)
+	}
+}
 
 func (n *PodFuseNode) Readdir(ctx context.Context) (fusefs.DirStream, syscall.Errno) {
 	es, err := fs.ReadDir(n.fsys, ".")
@@ -39,22 +88,46 @@ func (n *PodFuseNode) Readdir(ctx context.Context) (fusefs.DirStream, syscall.Er
 	}
 	entries := make([]fuse.DirEntry, len(es))
 	for i, e := range es {
-		mode := uint32(e.Type().Perm())
-		if e.IsDir() {
-			mode |= fuse.S_IFDIR
-		}
-		if e.Type()&fs.ModeSymlink == fs.ModeSymlink {
-			mode |= fuse.S_IFLNK
-		}
 		entries[i] = fuse.DirEntry{
-			Mode: mode,
+			Mode: toFuseType(e.Type()),
 			Name: e.Name(),
 		}
+		if inf, err := e.Info(); err == nil {
+			if stat, ok := inf.Sys().(*LinuxStat_t); ok {
+				entries[i].Ino = n.inode(stat)
+				entries[i].Mode = stat.Mode
+			}
+		}
+	}
+	if n.meta != nil {
+		entries = append(entries, fuse.DirEntry{Mode: fuse.S_IFDIR, Name: metaDirName})
 	}
 	return fusefs.NewListDirStream(entries), 0
 }
 
+func toFuseType(t fs.FileMode) uint32 {
+	switch {
+	case t&fs.ModeDir != 0:
+		return fuse.S_IFDIR
+	case t&fs.ModeSymlink != 0:
+		return fuse.S_IFLNK
+	case t&fs.ModeNamedPipe != 0:
+		return syscall.S_IFIFO
+	case t&fs.ModeSocket != 0:
+		return syscall.S_IFSOCK
+	case t&fs.ModeCharDevice != 0:
+		return syscall.S_IFCHR
+	case t&fs.ModeDevice != 0:
+		return syscall.S_IFBLK
+	}
+	return fuse.S_IFREG
+}
+
 func (n *PodFuseNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fusefs.Inode, syscall.Errno) {
+	if n.meta != nil && name == metaDirName {
+		return n.lookupMeta(ctx, out), fusefs.OK
+	}
+
 	inf, err := fs.Stat(n.fsys, name)
 	if err != nil {
 		return nil, fusefs.ToErrno(err)
@@ -62,29 +135,64 @@ func (n *PodFuseNode) Lookup(ctx context.Context, name string, out *fuse.EntryOu
 
 	var attr fusefs.StableAttr
 	if stat, ok := inf.Sys().(*LinuxStat_t); ok {
-		if stat.Ino == 1 {
+		attr.Ino = n.inode(stat)
+		if attr.Ino == 1 {
 			return nil, syscall.EPERM
 		}
-		attr.Ino = stat.Ino
 		attr.Mode = stat.Mode
+		n.setAttr(&out.Attr, stat)
 	}
-	var node *PodFuseNode
-	if inf.IsDir() {
-		subfs, err := fs.Sub(n.fsys, name)
-		if err != nil {
-			return nil, fusefs.ToErrno(err)
-		}
-		node = &PodFuseNode{fsys: subfs}
-	} else {
-		node = &PodFuseNode{
-			fsys: n.fsys,
-			file: name,
-		}
+	node, err := n.newChild(name, inf.IsDir())
+	if err != nil {
+		return nil, fusefs.ToErrno(err)
 	}
 	ch := n.NewInode(ctx, node, attr)
 	return ch, fusefs.OK
 }
 
+// lookupMeta returns the inode of the metadata directory.  The inode is
+// created once and kept during the mount.
+func (n *PodFuseNode) lookupMeta(ctx context.Context, out *fuse.EntryOut) *fusefs.Inode {
+	out.Mode = fuse.S_IFDIR | 0555
+	if ch := n.GetChild(metaDirName); ch != nil {
+		return ch
+	}
+	return n.NewPersistentInode(ctx, n.meta, fusefs.StableAttr{Mode: fuse.S_IFDIR})
+}
+
+// newChild returns a node for the entry in the directory node.
+func (n *PodFuseNode) newChild(name string, isDir bool) (*PodFuseNode, error) {
+	if isDir {
+		// fs.Sub rejects names which are not valid UTF-8, so the file
+		// systems implementing fs.SubFS are called directly
+		var subfs fs.FS
+		var err error
+		if fsys, ok := n.fsys.(fs.SubFS); ok {
+			subfs, err = fsys.Sub(name)
+		} else {
+			subfs, err = fs.Sub(n.fsys, name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &PodFuseNode{
+			fsys:     subfs,
+			writable: n.writable,
+			xattrs:   n.xattrs,
+			source:   n.source,
+			root:     n.root,
+		}, nil
+	}
+	return &PodFuseNode{
+		fsys:     n.fsys,
+		file:     name,
+		writable: n.writable,
+		xattrs:   n.xattrs,
+		source:   n.source,
+		root:     n.root,
+	}, nil
+}
+
 func (n *PodFuseNode) Getattr(ctx context.Context, f fusefs.FileHandle, out *fuse.AttrOut) syscall.Errno {
 	inf, err := fs.Stat(n.fsys, n.file)
 	if err != nil {
@@ -92,56 +200,156 @@ func (n *PodFuseNode) Getattr(ctx context.Context, f fusefs.FileHandle, out *fus
 	}
 
 	if stat, ok := inf.Sys().(*LinuxStat_t); ok {
-		out.Ino = stat.Ino
-		out.Mode = stat.Mode
-		out.Size = uint64(stat.Size)
-		out.Blocks = uint64(stat.Blocks)
-		out.Atime = uint64(stat.Atim.Sec)
-		out.Atimensec = uint32(stat.Atim.Nsec)
-		out.Mtime = uint64(stat.Mtim.Sec)
-		out.Mtimensec = uint32(stat.Mtim.Nsec)
-		out.Ctime = uint64(stat.Ctim.Sec)
-		out.Ctimensec = uint32(stat.Ctim.Nsec)
-		out.Nlink = uint32(stat.Nlink)
-		out.Uid = uint32(stat.Uid)
-		out.Gid = uint32(stat.Gid)
-		out.Rdev = uint32(stat.Rdev)
+		n.setAttr(&out.Attr, stat)
+	}
+	if h, ok := f.(*podWriteHandle); ok {
+		out.Size = uint64(h.size())
 	}
 	return fusefs.OK
 }
 
+// nextRootID is the last ID assigned to roots of filesystems by newRootID.
+var nextRootID uint64
+
+// newRootID returns a new ID of the root of a filesystem in the mount.
+func newRootID() uint64 {
+	return atomic.AddUint64(&nextRootID, 1)
+}
+
+// inode returns the inode number of the file in the mount.  The inode number
+// on the container is used as is for the single root.  Otherwise it is mixed
+// with the root and the device, since go-fuse shares nodes with the same
+// inode number, and containers from the same image have the same inode
+// numbers.
+func (n *PodFuseNode) inode(stat *LinuxStat_t) uint64 {
+	if n.root == 0 {
+		return stat.Ino
+	}
+	var buf [24]byte
+	binary.LittleEndian.PutUint64(buf[0:], n.root)
+	binary.LittleEndian.PutUint64(buf[8:], stat.Dev)
+	binary.LittleEndian.PutUint64(buf[16:], stat.Ino)
+	h := fnv.New64a()
+	h.Write(buf[:])
+	// Avoid the root inode and the automatic inodes of go-fuse in the
+	// upper half
+	ino := h.Sum64() &^ (1 << 63)
+	if ino <= 1 {
+		ino += 2
+	}
+	return ino
+}
+
+func (n *PodFuseNode) setAttr(out *fuse.Attr, stat *LinuxStat_t) {
+	out.Ino = n.inode(stat)
+	out.Mode = stat.Mode
+	out.Size = uint64(stat.Size)
+	out.Blocks = uint64(stat.Blocks)
+	out.Atime = uint64(stat.Atim.Sec)
+	out.Atimensec = uint32(stat.Atim.Nsec)
+	out.Mtime = uint64(stat.Mtim.Sec)
+	out.Mtimensec = uint32(stat.Mtim.Nsec)
+	out.Ctime = uint64(stat.Ctim.Sec)
+	out.Ctimensec = uint32(stat.Ctim.Nsec)
+	out.Nlink = uint32(stat.Nlink)
+	out.Uid = uint32(stat.Uid)
+	out.Gid = uint32(stat.Gid)
+	out.Rdev = uint32(stat.Rdev)
+}
+
 func (n *PodFuseNode) Setattr(ctx context.Context, f fusefs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
-	return syscall.EPERM
+	wfs, ok := n.writableFS()
+	if !ok {
+		return syscall.EPERM
+	}
+
+	if size, ok := in.GetSize(); ok {
+		if h, ok := f.(*podWriteHandle); ok {
+			h.truncate(int64(size))
+		} else if err := wfs.Truncate(n.file, int64(size)); err != nil {
+			return fusefs.ToErrno(err)
+		}
+	}
+	if mode, ok := in.GetMode(); ok {
+		if err := wfs.Chmod(n.file, fromRawMode(mode)); err != nil {
+			return fusefs.ToErrno(err)
+		}
+	}
+	uid, uok := in.GetUID()
+	gid, gok := in.GetGID()
+	if uok || gok {
+		u, g := -1, -1
+		if uok {
+			u = int(uid)
+		}
+		if gok {
+			g = int(gid)
+		}
+		if err := wfs.Chown(n.file, u, g); err != nil {
+			return fusefs.ToErrno(err)
+		}
+	}
+	atime, aok := in.GetATime()
+	mtime, mok := in.GetMTime()
+	if aok || mok {
+		if err := wfs.Chtimes(n.file, atime, mtime); err != nil {
+			return fusefs.ToErrno(err)
+		}
+	}
+	return n.Getattr(ctx, f, out)
 }
 
 func (f *PodFuseNode) Open(ctx context.Context, flags uint32) (fh fusefs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
 	if int(flags)&os.O_WRONLY == os.O_WRONLY || int(flags)&os.O_RDWR == os.O_RDWR {
-		return nil, 0, syscall.EPERM
+		if _, ok := f.writableFS(); !ok {
+			return nil, 0, syscall.EPERM
+		}
+		h := newWriteHandle(f.fsys, f.file)
+		if int(flags)&os.O_TRUNC == os.O_TRUNC {
+			h.dirty = true
+		} else if err := h.load(); err != nil {
+			return nil, 0, fusefs.ToErrno(err)
+		}
+		return h, fuse.FOPEN_DIRECT_IO, fusefs.OK
 	}
 
 	src, err := f.fsys.Open(f.file)
 	if err != nil {
 		return nil, 0, fusefs.ToErrno(err)
 	}
-	return src, fuse.FOPEN_NONSEEKABLE, fusefs.OK
+	return src, 0, fusefs.OK
 }
 
 func (f *PodFuseNode) Read(ctx context.Context, h fusefs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
-	r := h.(io.ReadCloser)
+	r, ok := h.(io.ReaderAt)
+	if !ok {
+		return nil, syscall.EBADF
+	}
 
-	_, err := io.CopyN(io.Discard, r, off)
+	n, err := r.ReadAt(dest, off)
 	if err != nil && err != io.EOF {
-		if err == io.EOF {
-			r.Close()
-		}
 		return nil, fusefs.ToErrno(err)
 	}
+	return fuse.ReadResultData(dest[:n]), fusefs.OK
+}
 
-	_, err = r.Read(dest)
-	if err != nil && err != io.EOF {
-		return nil, fusefs.ToErrno(err)
+func (f *PodFuseNode) Lseek(ctx context.Context, h fusefs.FileHandle, off uint64, whence uint32) (uint64, syscall.Errno) {
+	var out fuse.AttrOut
+	if errno := f.Getattr(ctx, h, &out); errno != fusefs.OK {
+		return 0, errno
 	}
-	return fuse.ReadResultData(dest), fusefs.OK
+	if off >= out.Size {
+		return 0, syscall.ENXIO
+	}
+
+	// The remote file is treated as having no holes
+	switch whence {
+	case seekData:
+		return off, fusefs.OK
+	case seekHole:
+		return out.Size, fusefs.OK
+	}
+	return 0, syscall.EINVAL
 }
 
 func (f *PodFuseNode) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
@@ -150,6 +358,10 @@ func (f *PodFuseNode) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
 }
 
 func (f *PodFuseNode) Release(ctx context.Context, h fusefs.FileHandle) syscall.Errno {
+	if h, ok := h.(*podWriteHandle); ok {
+		return fusefs.ToErrno(h.flush())
+	}
+
 	r := h.(io.ReadCloser)
 	err := r.Close()
 	if err != nil {
@@ -158,20 +370,285 @@ func (f *PodFuseNode) Release(ctx context.Context, h fusefs.FileHandle) syscall.
 	return fusefs.OK
 }
 
+func (f *PodFuseNode) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (*fusefs.Inode, fusefs.FileHandle, uint32, syscall.Errno) {
+	wfs, ok := f.writableFS()
+	if !ok {
+		return nil, nil, 0, syscall.EPERM
+	}
+	err := wfs.WriteFile(name, &bytes.Buffer{}, fromRawMode(mode))
+	if err != nil {
+		return nil, nil, 0, fusefs.ToErrno(err)
+	}
+	node, errno := f.Lookup(ctx, name, out)
+	if errno != fusefs.OK {
+		return nil, nil, 0, errno
+	}
+	h := newWriteHandle(f.fsys, name)
+	return node, h, fuse.FOPEN_DIRECT_IO, fusefs.OK
+}
+
+func (f *PodFuseNode) Write(ctx context.Context, h fusefs.FileHandle, data []byte, off int64) (uint32, syscall.Errno) {
+	w, ok := h.(*podWriteHandle)
+	if !ok {
+		return 0, syscall.EBADF
+	}
+	return uint32(w.writeAt(data, off)), fusefs.OK
+}
+
+func (f *PodFuseNode) Flush(ctx context.Context, h fusefs.FileHandle) syscall.Errno {
+	if w, ok := h.(*podWriteHandle); ok {
+		return fusefs.ToErrno(w.flush())
+	}
+	return fusefs.OK
+}
+
+func (f *PodFuseNode) Fsync(ctx context.Context, h fusefs.FileHandle, flags uint32) syscall.Errno {
+	if w, ok := h.(*podWriteHandle); ok {
+		return fusefs.ToErrno(w.flush())
+	}
+	return fusefs.OK
+}
+
 func (f *PodFuseNode) Mknod(ctx context.Context, name string, mode uint32, dev uint32, out *fuse.EntryOut) (*fusefs.Inode, syscall.Errno) {
 	return nil, syscall.EPERM
 }
 
 func (f *PodFuseNode) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fusefs.Inode, syscall.Errno) {
-	return nil, syscall.EPERM
+	wfs, ok := f.writableFS()
+	if !ok {
+		return nil, syscall.EPERM
+	}
+	if err := wfs.Mkdir(name, fromRawMode(mode)); err != nil {
+		return nil, fusefs.ToErrno(err)
+	}
+	return f.Lookup(ctx, name, out)
 }
 
 func (f *PodFuseNode) Link(ctx context.Context, target fusefs.InodeEmbedder, name string, out *fuse.EntryOut) (node *fusefs.Inode, errno syscall.Errno) {
-	return nil, syscall.EPERM
+	wfs, ok := f.writableFS()
+	if !ok {
+		return nil, syscall.EPERM
+	}
+	if !f.sameFS(target.EmbeddedInode()) {
+		return nil, syscall.EXDEV
+	}
+	if err := wfs.Link(f.relPath(target.EmbeddedInode()), name); err != nil {
+		return nil, fusefs.ToErrno(err)
+	}
+	return f.Lookup(ctx, name, out)
 }
+
 func (f *PodFuseNode) Unlink(ctx context.Context, name string) syscall.Errno {
-	return syscall.EPERM
+	wfs, ok := f.writableFS()
+	if !ok {
+		return syscall.EPERM
+	}
+	return fusefs.ToErrno(wfs.Remove(name))
 }
+
+func (f *PodFuseNode) Rmdir(ctx context.Context, name string) syscall.Errno {
+	wfs, ok := f.writableFS()
+	if !ok {
+		return syscall.EPERM
+	}
+	return fusefs.ToErrno(wfs.RemoveDir(name))
+}
+
 func (f *PodFuseNode) Symlink(ctx context.Context, target, name string, out *fuse.EntryOut) (node *fusefs.Inode, errno syscall.Errno) {
-	return nil, syscall.EPERM
+	wfs, ok := f.writableFS()
+	if !ok {
+		return nil, syscall.EPERM
+	}
+	if err := wfs.Symlink(target, name); err != nil {
+		return nil, fusefs.ToErrno(err)
+	}
+	return f.Lookup(ctx, name, out)
+}
+
+func (f *PodFuseNode) Rename(ctx context.Context, name string, newParent fusefs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	wfs, ok := f.writableFS()
+	if !ok {
+		return syscall.EPERM
+	}
+	if flags != 0 {
+		return syscall.EINVAL
+	}
+	if !f.sameFS(newParent.EmbeddedInode()) {
+		return syscall.EXDEV
+	}
+	newPath := path.Join(f.relPath(newParent.EmbeddedInode()), newName)
+	if err := wfs.Rename(name, newPath); err != nil {
+		return fusefs.ToErrno(err)
+	}
+
+	// The inode is moved to the new parent after the rename succeeds, so the
+	// node and its descendants should refer to the new location.
+	parent, ok := newParent.(*PodFuseNode)
+	if !ok {
+		return fusefs.OK
+	}
+	if ch := f.GetChild(name); ch != nil {
+		if node, ok := ch.Operations().(*PodFuseNode); ok {
+			parent.move(node, newName)
+		}
+	}
+	return fusefs.OK
+}
+
+// move updates the child node and its descendants to be placed at name in
+// the directory node.
+func (n *PodFuseNode) move(child *PodFuseNode, name string) {
+	moved, err := n.newChild(name, child.IsDir())
+	if err != nil {
+		return
+	}
+	child.fsys = moved.fsys
+	child.file = moved.file
+	for name, ch := range child.Children() {
+		if node, ok := ch.Operations().(*PodFuseNode); ok {
+			child.move(node, name)
+		}
+	}
+}
+
+// writableFS returns the file system to modify files if the node is mounted
+// as read-write.
+func (f *PodFuseNode) writableFS() (WriteFS, bool) {
+	if !f.writable {
+		return nil, false
+	}
+	wfs, ok := f.fsys.(WriteFS)
+	return wfs, ok
+}
+
+// sameFS returns true if the inode belongs to the same remote filesystem as
+// the node.  Filesystems of different pods are mounted under DirNode.
+func (f *PodFuseNode) sameFS(target *fusefs.Inode) bool {
+	if _, ok := target.Operations().(*PodFuseNode); !ok {
+		return false
+	}
+	return fsRoot(f.EmbeddedInode()) == fsRoot(target)
+}
+
+// fsRoot returns the root inode of the remote filesystem containing the
+// inode.
+func fsRoot(n *fusefs.Inode) *fusefs.Inode {
+	for {
+		_, parent := n.Parent()
+		if parent == nil {
+			return n
+		}
+		if _, ok := parent.Operations().(*PodFuseNode); !ok {
+			return n
+		}
+		n = parent
+	}
+}
+
+// relPath returns the path to the inode relative to the directory of the
+// node.
+func (f *PodFuseNode) relPath(target *fusefs.Inode) string {
+	rel, err := filepath.Rel(f.Path(nil), target.Path(nil))
+	if err != nil {
+		return target.Path(nil)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// podWriteHandle is a file handle opened for writing.  It buffers the whole
+// content of the file, and replaces the remote file on flush.
+type podWriteHandle struct {
+	mu    sync.Mutex
+	fsys  fs.FS
+	name  string
+	gen   uint64
+	buf   []byte
+	dirty bool
+}
+
+func newWriteHandle(fsys fs.FS, name string) *podWriteHandle {
+	return &podWriteHandle{
+		fsys: fsys,
+		name: name,
+		gen:  generation(fsys),
+	}
+}
+
+func (h *podWriteHandle) load() error {
+	r, err := h.fsys.Open(h.name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	h.buf = buf
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *podWriteHandle) size() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return int64(len(h.buf))
+}
+
+func (h *podWriteHandle) ReadAt(dest []byte, off int64) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if off >= int64(len(h.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(dest, h.buf[off:])
+	if n < len(dest) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (h *podWriteHandle) writeAt(data []byte, off int64) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if end := off + int64(len(data)); end > int64(len(h.buf)) {
+		buf := make([]byte, end)
+		copy(buf, h.buf)
+		h.buf = buf
+	}
+	h.dirty = true
+	return copy(h.buf[off:], data)
+}
+
+func (h *podWriteHandle) truncate(size int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if size <= int64(len(h.buf)) {
+		h.buf = h.buf[:size]
+	} else {
+		buf := make([]byte, size)
+		copy(buf, h.buf)
+		h.buf = buf
+	}
+	h.dirty = true
+}
+
+func (h *podWriteHandle) flush() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.dirty {
+		return nil
+	}
+	// The content is not written to the replaced container
+	if generation(h.fsys) != h.gen {
+		return syscall.ESTALE
+	}
+	err := h.fsys.(WriteFS).WriteFile(h.name, bytes.NewReader(h.buf), 0644)
+	if err != nil {
+		return err
+	}
+	h.dirty = false
+	return nil
 }
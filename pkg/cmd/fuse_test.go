@@ -0,0 +1,258 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"testing"
+	"testing/fstest"
+
+	fusefs "github.com/hanwen/go-fuse/v2/fs"
+)
+
+// mountTestNode mounts the node in a temporary directory, and returns the
+// mountpoint.  The test is skipped if FUSE is not available.
+func mountTestNode(t *testing.T, root fusefs.InodeEmbedder) string {
+	t.Helper()
+	mnt := t.TempDir()
+	opt := &fusefs.Options{}
+	opt.DirectMount = true
+	opt.FsName = "kubectl-mount-test"
+	srv, err := fusefs.Mount(mnt, root, opt)
+	if err != nil {
+		t.Skipf("FUSE is not available: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := srv.Unmount(); err != nil {
+			t.Error(err)
+		}
+	})
+	return mnt
+}
+
+func TestPodFuseNode(t *testing.T) {
+	for _, flavor := range testFlavors {
+		t.Run(flavor.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := testFiles()
+			files["names/\xff\xfe"] = &fstest.MapFile{Mode: fs.ModeDir | 0755, ModTime: testModTime}
+			files["names/\xff\xfe/file"] = &fstest.MapFile{Data: []byte("in a dir with a non-UTF-8 name"), Mode: 0644, ModTime: testModTime}
+			files["link"] = &fstest.MapFile{Data: []byte("dir/nested.txt"), Mode: fs.ModeSymlink | 0777}
+			writeLocalFiles(t, dir, files)
+
+			f := &PodFS{Executor: newFakeExecutor(localDirFS(dir)), Pwd: "/", Capabilities: flavor.caps}
+			mnt := mountTestNode(t, &PodFuseNode{fsys: f})
+
+			entries, err := os.ReadDir(filepath.Join(mnt, "names"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			want := []string{"a:b -> c", "with\nnewline", "with space", "with\ttab", "\xff\xfe"}
+			sort.Strings(want)
+			if len(names) != len(want) {
+				t.Fatalf("names = %q, want %q", names, want)
+			}
+			for i := range names {
+				if names[i] != want[i] {
+					t.Errorf("names = %q, want %q", names, want)
+				}
+			}
+
+			for name, f := range files {
+				p := filepath.Join(mnt, filepath.FromSlash(name))
+				inf, err := os.Lstat(p)
+				if err != nil {
+					t.Errorf("%q: %v", name, err)
+					continue
+				}
+				if inf.Mode() != f.Mode {
+					t.Errorf("%q: mode = %v, want %v", name, inf.Mode(), f.Mode)
+				}
+				if f.Mode.IsRegular() {
+					data, err := os.ReadFile(p)
+					if err != nil {
+						t.Errorf("%q: %v", name, err)
+					} else if !bytes.Equal(data, f.Data) {
+						t.Errorf("%q: content = %q, want %q", name, data, f.Data)
+					}
+					if flavor.caps.statStrategy() == statStat && !inf.ModTime().Equal(f.ModTime) {
+						t.Errorf("%q: mtime = %v, want %v", name, inf.ModTime(), f.ModTime)
+					}
+				}
+			}
+
+			target, err := os.Readlink(filepath.Join(mnt, "link"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if target != "dir/nested.txt" {
+				t.Errorf("target = %q, want %q", target, "dir/nested.txt")
+			}
+
+			var st syscall.Statfs_t
+			if err := syscall.Statfs(mnt, &st); err != nil {
+				t.Fatal(err)
+			}
+			if st.Blocks == 0 || st.Bavail > st.Bfree || st.Bfree > st.Blocks {
+				t.Errorf("unexpected statfs: %+v", st)
+			}
+
+			if err := os.Mkdir(filepath.Join(mnt, "new"), 0755); err == nil {
+				t.Error("mkdir on the read-only mount succeeded")
+			}
+		})
+	}
+}
+
+func TestPodFuseNodeDevices(t *testing.T) {
+	files := fstest.MapFS{
+		"null": {Mode: fs.ModeDevice | fs.ModeCharDevice | 0666, ModTime: testModTime,
+			Sys: &LinuxStat_t{Ino: 100, Rdev: mkdev(1, 3)}},
+		"sda": {Mode: fs.ModeDevice | 0660, ModTime: testModTime,
+			Sys: &LinuxStat_t{Ino: 101, Uid: 0, Gid: 6, Rdev: mkdev(8, 16)}},
+		"fifo": {Mode: fs.ModeNamedPipe | 0600, ModTime: testModTime,
+			Sys: &LinuxStat_t{Ino: 102, Uid: 1000, Gid: 1000}},
+	}
+	for _, flavor := range testFlavors {
+		t.Run(flavor.name, func(t *testing.T) {
+			f := &PodFS{Executor: newFakeExecutor(files), Pwd: "/", Capabilities: flavor.caps}
+			mnt := mountTestNode(t, &PodFuseNode{fsys: f})
+
+			for name, f := range files {
+				inf, err := os.Lstat(filepath.Join(mnt, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := f.Sys.(*LinuxStat_t)
+				st := inf.Sys().(*syscall.Stat_t)
+				if inf.Mode() != f.Mode {
+					t.Errorf("%s: mode = %v, want %v", name, inf.Mode(), f.Mode)
+				}
+				if st.Ino != want.Ino || uint64(st.Rdev) != want.Rdev || st.Uid != want.Uid || st.Gid != want.Gid {
+					t.Errorf("%s: unexpected stat: %+v", name, st)
+				}
+			}
+		})
+	}
+}
+
+// sameInodeFiles returns files whose inode numbers are the same as in the
+// other containers from the same image, with the content.
+func sameInodeFiles(content string) fstest.MapFS {
+	return fstest.MapFS{
+		"etc": {Mode: fs.ModeDir | 0755, ModTime: testModTime,
+			Sys: &LinuxStat_t{Ino: 41}},
+		"etc/nginx.conf": {Data: []byte(content), Mode: 0644, ModTime: testModTime,
+			Sys: &LinuxStat_t{Ino: 42, Nlink: 2}},
+		"etc/hardlink": {Data: []byte(content), Mode: 0644, ModTime: testModTime,
+			Sys: &LinuxStat_t{Ino: 42, Nlink: 2}},
+	}
+}
+
+// testSameInodes tests that the files under the directories of the mount are
+// distinct, although they have the same inode numbers on the containers.
+func testSameInodes(t *testing.T, mnt string, dirs ...string) {
+	t.Helper()
+	inodes := map[uint64]string{}
+	for _, dir := range dirs {
+		p := filepath.Join(mnt, dir, "etc/nginx.conf")
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != dir {
+			t.Errorf("%s: content = %q, want %q", p, data, dir)
+		}
+
+		var st, link syscall.Stat_t
+		if err := syscall.Stat(p, &st); err != nil {
+			t.Fatal(err)
+		}
+		if other, ok := inodes[st.Ino]; ok {
+			t.Errorf("%s: inode %d is the same as %s", p, st.Ino, other)
+		}
+		inodes[st.Ino] = p
+
+		// Hard links in a container still share the inode
+		if err := syscall.Stat(filepath.Join(mnt, dir, "etc/hardlink"), &link); err != nil {
+			t.Fatal(err)
+		}
+		if link.Ino != st.Ino {
+			t.Errorf("%s: inode of the hard link = %d, want %d", p, link.Ino, st.Ino)
+		}
+	}
+}
+
+func TestPodFuseNodeAllPods(t *testing.T) {
+	dir := NewDirNode()
+	for _, name := range []string{"pod-a", "pod-b"} {
+		f := &PodFS{Executor: newFakeExecutor(sameInodeFiles(name)), Pwd: "/", Capabilities: gnuCapabilities}
+		dir.Add(name, func() (fusefs.InodeEmbedder, func(), error) {
+			return &PodFuseNode{fsys: f, root: newRootID()}, func() {}, nil
+		})
+	}
+	mnt := mountTestNode(t, dir)
+	testSameInodes(t, mnt, "pod-a", "pod-b")
+}
+
+func TestPodFuseNodeAllContainers(t *testing.T) {
+	dir := NewDirNode()
+	for _, name := range []string{"init", "main", "sidecar"} {
+		f := &PodFS{Executor: newFakeExecutor(sameInodeFiles(name)), Pwd: "/", Capabilities: gnuCapabilities}
+		dir.Add(name, func() (fusefs.InodeEmbedder, func(), error) {
+			return &PodFuseNode{fsys: f, root: newRootID()}, func() {}, nil
+		})
+	}
+	mnt := mountTestNode(t, dir)
+	testSameInodes(t, mnt, "init", "main", "sidecar")
+}
+
+func TestDirNodeLookup(t *testing.T) {
+	dir := NewDirNode()
+	opening := make(chan struct{})
+	opened := make(chan struct{})
+	dir.Add("slow", func() (fusefs.InodeEmbedder, func(), error) {
+		close(opening)
+		<-opened
+		f := &PodFS{Executor: newFakeExecutor(sameInodeFiles("slow")), Pwd: "/", Capabilities: gnuCapabilities}
+		return &PodFuseNode{fsys: f, root: newRootID()}, func() {}, nil
+	})
+	mnt := mountTestNode(t, dir)
+
+	done := make(chan error)
+	go func() {
+		_, err := os.Stat(filepath.Join(mnt, "slow"))
+		done <- err
+	}()
+	<-opening
+
+	// Entries are added and removed while the entry is being opened
+	dir.Add("fast", func() (fusefs.InodeEmbedder, func(), error) {
+		return NewDirNode(), func() {}, nil
+	})
+	dir.Remove("fast")
+	stream, errno := dir.Readdir(context.Background())
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	var names []string
+	for stream.HasNext() {
+		e, _ := stream.Next()
+		names = append(names, e.Name)
+	}
+	if len(names) != 1 || names[0] != "slow" {
+		t.Errorf("entries = %q, want slow", names)
+	}
+	close(opened)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// findMountHolders returns local processes whose working directory, root
+// directory or open files are in the mountpoint, by scanning /proc.
+// Processes which cannot be inspected are skipped.
+func findMountHolders(mountpoint string) []mountHolder {
+	dirs, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	self := os.Getpid()
+
+	var holders []mountHolder
+	for _, d := range dirs {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		procDir := filepath.Join("/proc", d.Name())
+		links := []string{filepath.Join(procDir, "cwd"), filepath.Join(procDir, "root")}
+		if fds, err := os.ReadDir(filepath.Join(procDir, "fd")); err == nil {
+			for _, fd := range fds {
+				links = append(links, filepath.Join(procDir, "fd", fd.Name()))
+			}
+		}
+		for _, link := range links {
+			target, err := os.Readlink(link)
+			if err != nil || !inMountpoint(target, mountpoint) {
+				continue
+			}
+			comm, _ := os.ReadFile(filepath.Join(procDir, "comm"))
+			holders = append(holders, mountHolder{
+				PID:     pid,
+				Command: strings.TrimSpace(string(comm)),
+				Path:    target,
+			})
+			break
+		}
+	}
+	return holders
+}
+
+func inMountpoint(p, mountpoint string) bool {
+	return p == mountpoint || strings.HasPrefix(p, mountpoint+"/")
+}
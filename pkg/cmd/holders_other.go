@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package cmd
+
+// findMountHolders returns nil since /proc is not available.
+func findMountHolders(mountpoint string) []mountHolder {
+	return nil
+}
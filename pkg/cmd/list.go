@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/printers"
+)
+
+const listExample = `
+	# List active mounts
+	kubectl mount list`
+
+type ListOptions struct {
+	genericclioptions.IOStreams
+}
+
+func NewListOptions(streams genericclioptions.IOStreams) *ListOptions {
+	return &ListOptions{
+		IOStreams: streams,
+	}
+}
+
+// NewCmdList provides a cobra command wrapping ListOptions
+func NewCmdList(streams genericclioptions.IOStreams) *cobra.Command {
+	o := NewListOptions(streams)
+
+	cmd := &cobra.Command{
+		Use:          "list",
+		Aliases:      []string{"ls"},
+		Short:        "List active mounts",
+		Example:      listExample,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return o.RunList()
+		},
+	}
+	return cmd
+}
+
+// RunList prints the active mounts recorded in the state directory
+func (o *ListOptions) RunList() error {
+	states, err := loadMountStates()
+	if err != nil {
+		return err
+	}
+	if len(states) == 0 {
+		fmt.Fprintln(o.ErrOut, "No mounts found.")
+		return nil
+	}
+
+	w := printers.GetNewTabWriter(o.Out)
+	fmt.Fprintln(w, "MOUNTPOINT\tNAMESPACE\tPOD\tCONTAINER\tREMOTE\tPID\tAGE")
+	for _, s := range states {
+		container := s.Container
+		if container == "" {
+			container = "<all>"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			s.MountPoint, s.Namespace, s.Pod, container, s.RemoteDir, s.PID,
+			duration.HumanDuration(time.Since(s.Started)))
+	}
+	return w.Flush()
+}
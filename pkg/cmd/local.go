@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	executorKubernetes = "kubernetes"
+	executorLocal      = "local"
+
+	// localContainerName is the name of the container standing for the
+	// local machine with --executor=local.
+	localContainerName = "local"
+)
+
+// LocalExecutor is an Executor running commands on the local machine instead
+// of a container in a pod.  It is used to develop and test the filesystem
+// without Kubernetes.  The commands run in the root directory by chroot, or
+// in the namespaces of a local process by nsenter like a container.  Both
+// require root.
+type LocalExecutor struct {
+	// Root is the root directory of the commands.  The commands run in the
+	// root directory of the local machine if it is empty.
+	Root string
+
+	// PID is the process whose namespaces and root directory the commands
+	// enter.  The namespaces are not entered if it is zero.
+	PID int
+}
+
+func (e *LocalExecutor) command(ctx context.Context, command []string) *exec.Cmd {
+	if e.PID != 0 {
+		command = append([]string{
+			"nsenter", "--target", strconv.Itoa(e.PID),
+			"--mount", "--uts", "--ipc", "--net", "--pid", "--root", "--wd", "--",
+		}, command...)
+	}
+	if e.Root != "" {
+		// The chroot command looks up the command in the root directory,
+		// unlike the Chroot attribute of the process
+		command = append([]string{"chroot", e.Root}, command...)
+	}
+	return exec.CommandContext(ctx, command[0], command[1:]...)
+}
+
+// wait waits the command, and returns RemoteCommandErr with the stderr if
+// the command exits with non-zero code like PodExecutor.
+func (e *LocalExecutor) wait(c *exec.Cmd, stderr *bytes.Buffer) error {
+	err := c.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &RemoteCommandErr{
+			Stderr: stderr.Bytes(),
+			Err:    err,
+		}
+	}
+	return err
+}
+
+func (e *LocalExecutor) Run(ctx context.Context, command []string) ([]byte, error) {
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	c := e.command(ctx, command)
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+	if err := e.wait(c, &stderr); err != nil {
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+func (e *LocalExecutor) RunRead(ctx context.Context, command []string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	r, w := io.Pipe()
+	var stderr bytes.Buffer
+	c := e.command(ctx, command)
+	c.Stdout = w
+	c.Stderr = &stderr
+	if err := c.Start(); err != nil {
+		cancel()
+		return nil, err
+	}
+	go func() {
+		defer cancel()
+		w.CloseWithError(e.wait(c, &stderr))
+	}()
+	return &streamReader{PipeReader: r, cancel: cancel}, nil
+}
+
+func (e *LocalExecutor) RunWrite(ctx context.Context, command []string, stdin io.Reader) error {
+	var stderr bytes.Buffer
+	c := e.command(ctx, command)
+	c.Stdin = stdin
+	c.Stdout = io.Discard
+	c.Stderr = &stderr
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return e.wait(c, &stderr)
+}
+
+func (e *LocalExecutor) RunStream(ctx context.Context, command []string, stdin io.Reader, stdout io.Writer) error {
+	var stderr bytes.Buffer
+	c := e.command(ctx, command)
+	c.Stdin = stdin
+	c.Stdout = stdout
+	c.Stderr = &stderr
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return e.wait(c, &stderr)
+}
+
+// localPod returns a pod standing for the local machine with
+// --executor=local, which has a single running container.
+func localPod(name, namespace string) *corev1.Pod {
+	hostname, _ := os.Hostname()
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: localContainerName}},
+			NodeName:   hostname,
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+		},
+	}
+}
@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// newLocalTestNode returns a writable root node of the local directory with
+// the local executor, like the filesystem mounted by --executor=local.
+func newLocalTestNode(t *testing.T, dir string) *PodFuseNode {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("the local executor requires the linux userland")
+	}
+	e := &LocalExecutor{}
+	caps, err := ProbeCapabilities(context.Background(), e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &PodFS{Executor: e, Pwd: dir, Capabilities: caps}
+	return &PodFuseNode{
+		fsys:     NewCachedFS(backend, time.Second, time.Second),
+		writable: true,
+		source: func() (*corev1.Pod, string) {
+			return localPod("local", "default"), localContainerName
+		},
+	}
+}
+
+func TestLocalExecutor(t *testing.T) {
+	e := &LocalExecutor{}
+	output, err := e.Run(context.Background(), []string{"sh", "-c", "echo out; echo err >&2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "out\n" {
+		t.Errorf("output = %q, want %q", output, "out\n")
+	}
+
+	_, err = e.Run(context.Background(), []string{"cat", filepath.Join(t.TempDir(), "missing")})
+	if !os.IsNotExist(toOSError(err)) {
+		t.Errorf("err = %v, want not exist", err)
+	}
+
+	// Closing the reader early kills the command
+	pidfile := filepath.Join(t.TempDir(), "pid")
+	r, err := e.RunRead(context.Background(), []string{"sh", "-c", `echo $$ >"$0"; exec yes`, pidfile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(r, make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	pid, err := os.ReadFile(pidfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		if _, err := os.Stat(filepath.Join("/proc", strings.TrimSpace(string(pid)))); os.IsNotExist(err) {
+			break
+		}
+		if i == 100 {
+			t.Fatal("the command is still running after the reader is closed")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if os.Geteuid() != 0 || runtime.GOOS != "linux" {
+		t.Skip("chroot and nsenter require root")
+	}
+	for _, e := range []*LocalExecutor{{Root: "/"}, {PID: os.Getpid()}} {
+		output, err := e.Run(context.Background(), []string{"sh", "-c", "echo $0", "ok"})
+		if err != nil {
+			t.Fatalf("%+v: %v", e, err)
+		}
+		if string(output) != "ok\n" {
+			t.Errorf("%+v: output = %q, want %q", e, output, "ok\n")
+		}
+	}
+}
+
+func TestLocalMount(t *testing.T) {
+	dir := t.TempDir()
+	writeLocalFiles(t, dir, testFiles())
+	mnt := mountTestNode(t, newLocalTestNode(t, dir))
+
+	// Reading
+	data, err := os.ReadFile(filepath.Join(mnt, "hello.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello world\n" {
+		t.Errorf("content = %q", data)
+	}
+	inf, err := os.Stat(filepath.Join(mnt, "dir/sub/deep"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inf.Size() != 5000 || inf.Mode() != 0644 || !inf.ModTime().Equal(testModTime) {
+		t.Errorf("unexpected stat: %v %v %v", inf.Size(), inf.Mode(), inf.ModTime())
+	}
+
+	// Writing through the mount is visible in the local directory
+	if err := os.WriteFile(filepath.Join(mnt, "new.txt"), []byte("new file"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(mnt, "newdir"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkdir(filepath.Join(mnt, "sticky"), 01777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(mnt, "newdir"), os.ModeSetgid|0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(mnt, "new.txt"), filepath.Join(mnt, "newdir/renamed.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("renamed.txt", filepath.Join(mnt, "newdir/link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(filepath.Join(mnt, "hello.txt"), 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(mnt, "empty"), 0604); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(mnt, "dir/.hidden")); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(1617280496, 123456789)
+	if err := os.Chtimes(filepath.Join(mnt, "dir/nested.txt"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = os.ReadFile(filepath.Join(dir, "newdir/renamed.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new file" {
+		t.Errorf("content = %q, want %q", data, "new file")
+	}
+	if inf, err := os.Stat(filepath.Join(dir, "newdir/renamed.txt")); err != nil || inf.Mode() != 0640 {
+		t.Errorf("stat = %v, %v, want mode 0640", inf, err)
+	}
+	if inf, err := os.Stat(filepath.Join(dir, "newdir")); err != nil || inf.Mode() != os.ModeDir|os.ModeSetgid|0750 {
+		t.Errorf("stat = %v, %v, want mode dgrwxr-x---", inf, err)
+	}
+	if inf, err := os.Stat(filepath.Join(dir, "sticky")); err != nil || inf.Mode()&os.ModeSticky == 0 {
+		t.Errorf("stat = %v, %v, want the sticky bit", inf, err)
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "newdir/link")); err != nil || target != "renamed.txt" {
+		t.Errorf("readlink = %q, %v", target, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "hello.txt")); err != nil || string(data) != "hello" {
+		t.Errorf("content = %q, %v", data, err)
+	}
+	if inf, err := os.Stat(filepath.Join(dir, "empty")); err != nil || inf.Mode() != 0604 {
+		t.Errorf("stat = %v, %v, want mode 0604", inf, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "dir/.hidden")); !os.IsNotExist(err) {
+		t.Errorf("stat of the removed file: %v", err)
+	}
+	if inf, err := os.Stat(filepath.Join(dir, "dir/nested.txt")); err != nil || !inf.ModTime().Equal(mtime) {
+		t.Errorf("stat = %v, %v, want mtime %v", inf, err, mtime)
+	}
+
+	// The mount shows the changes without waiting for the cache
+	entries, err := os.ReadDir(filepath.Join(mnt, "newdir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Name() != "link" || entries[1].Name() != "renamed.txt" {
+		t.Errorf("entries = %v", entries)
+	}
+}
@@ -0,0 +1,334 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	fusefs "github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// previousLogSuffix is the suffix of the file containing logs of the
+// previous instance of the container.
+const previousLogSuffix = ".previous"
+
+// LogDirNode is a directory in the metadata directory containing logs of
+// the containers in the pod.  Each container has a file named after the
+// container, and a restarted container also has a file of the logs of the
+// previous instance with the .previous suffix.
+type LogDirNode struct {
+	fusefs.Inode
+
+	meta *MetaDirNode
+}
+
+var _ = (fusefs.NodeReaddirer)((*LogDirNode)(nil))
+var _ = (fusefs.NodeLookuper)((*LogDirNode)(nil))
+var _ = (fusefs.NodeGetattrer)((*LogDirNode)(nil))
+
+func (n *LogDirNode) Readdir(ctx context.Context) (fusefs.DirStream, syscall.Errno) {
+	pod, _, err := n.meta.latestPod(ctx)
+	if err != nil {
+		return nil, syscall.EIO
+	}
+	names := logFileNames(pod)
+	entries := make([]fuse.DirEntry, len(names))
+	for i, name := range names {
+		entries[i] = fuse.DirEntry{Mode: fuse.S_IFREG, Name: name}
+	}
+	return fusefs.NewListDirStream(entries), 0
+}
+
+func (n *LogDirNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fusefs.Inode, syscall.Errno) {
+	out.Mode = fuse.S_IFREG | 0444
+	out.SetAttrTimeout(0)
+	if ch := n.GetChild(name); ch != nil {
+		return ch, fusefs.OK
+	}
+
+	pod, _, err := n.meta.latestPod(ctx)
+	if err != nil {
+		return nil, syscall.EIO
+	}
+	names := logFileNames(pod)
+	if i := sort.SearchStrings(names, name); i == len(names) || names[i] != name {
+		return nil, syscall.ENOENT
+	}
+	node := &LogFileNode{
+		meta:      n.meta,
+		container: strings.TrimSuffix(name, previousLogSuffix),
+		previous:  strings.HasSuffix(name, previousLogSuffix),
+	}
+	return n.NewPersistentInode(ctx, node, fusefs.StableAttr{Mode: fuse.S_IFREG}), fusefs.OK
+}
+
+func (n *LogDirNode) Getattr(ctx context.Context, f fusefs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = fuse.S_IFDIR | 0555
+	return fusefs.OK
+}
+
+// logFileNames returns names of the log files of the containers in the pod.
+// Ephemeral containers created by kubectl-mount are excluded.
+func logFileNames(pod *corev1.Pod) []string {
+	var names []string
+	add := func(name string) {
+		if strings.HasPrefix(name, ephemeralContainerPrefix) {
+			return
+		}
+		names = append(names, name)
+		if s := findContainerStatus(pod, name); s != nil && s.LastTerminationState.Terminated != nil {
+			names = append(names, name+previousLogSuffix)
+		}
+	}
+	for _, c := range pod.Spec.InitContainers {
+		add(c.Name)
+	}
+	for _, c := range pod.Spec.Containers {
+		add(c.Name)
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		add(c.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// maxLogSize is the maximum size of the logs kept in memory for a log file.
+// Older logs are dropped when the logs exceed the size.
+const maxLogSize = 16 << 20
+
+const (
+	// logSettleDelay is the pause of the log stream after which the current
+	// logs are considered retrieved.
+	logSettleDelay = 200 * time.Millisecond
+
+	// maxLogSettleDelay is the maximum time to wait for the current logs,
+	// since the logs of a busy container never pause.
+	maxLogSettleDelay = 2 * time.Second
+)
+
+// LogFileNode is a log file of the container.  The logs are streamed from a
+// single request following the logs while the file is open, so `tail -f`
+// streams the logs.  Opening the file waits until the current logs arrive,
+// and the size of the file grows as the following logs arrive.  Logs of the
+// previous instance are not followed.
+type LogFileNode struct {
+	fusefs.Inode
+
+	meta      *MetaDirNode
+	container string
+	previous  bool
+
+	mu     sync.Mutex
+	logs   *logBuffer
+	refs   int
+	cancel context.CancelFunc
+}
+
+var _ = (fusefs.NodeGetattrer)((*LogFileNode)(nil))
+var _ = (fusefs.NodeOpener)((*LogFileNode)(nil))
+var _ = (fusefs.NodeReader)((*LogFileNode)(nil))
+var _ = (fusefs.NodeReleaser)((*LogFileNode)(nil))
+
+func (n *LogFileNode) Getattr(ctx context.Context, f fusefs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = fuse.S_IFREG | 0444
+	out.SetTimeout(0)
+	if h, ok := f.(*logHandle); ok {
+		out.Size = uint64(h.size())
+	}
+	return fusefs.OK
+}
+
+func (n *LogFileNode) Open(ctx context.Context, flags uint32) (fusefs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	n.mu.Lock()
+	if n.refs == 0 {
+		if err := n.start(ctx); err != nil {
+			n.mu.Unlock()
+			return nil, 0, syscall.EIO
+		}
+	}
+	n.refs++
+	logs := n.logs
+	n.mu.Unlock()
+
+	select {
+	case <-logs.settled:
+	case <-ctx.Done():
+		n.Release(ctx, logs)
+		return nil, 0, syscall.EINTR
+	}
+	return logs.open(), fuse.FOPEN_DIRECT_IO, fusefs.OK
+}
+
+// start opens the stream of the logs, and copies the logs to the buffer
+// until the file is released.
+func (n *LogFileNode) start(ctx context.Context) error {
+	pod, _, err := n.meta.latestPod(ctx)
+	if err != nil {
+		return err
+	}
+	streamCtx, cancel := context.WithCancel(context.Background())
+	stream, err := n.meta.api.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: n.container,
+		Previous:  n.previous,
+		Follow:    !n.previous,
+	}).Stream(streamCtx)
+	if err != nil {
+		cancel()
+		return err
+	}
+	n.logs = newLogBuffer()
+	n.cancel = cancel
+	go func(buf *logBuffer) {
+		defer stream.Close()
+		defer buf.settle()
+		if n.previous {
+			io.Copy(buf, stream)
+			return
+		}
+
+		// The followed stream does not tell the end of the current logs,
+		// so the current logs end when the stream pauses.
+		quiet := time.AfterFunc(logSettleDelay, buf.settle)
+		defer quiet.Stop()
+		limit := time.AfterFunc(maxLogSettleDelay, buf.settle)
+		defer limit.Stop()
+		p := make([]byte, 32*1024)
+		for {
+			c, err := stream.Read(p)
+			if c > 0 {
+				buf.Write(p[:c])
+				quiet.Reset(logSettleDelay)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}(n.logs)
+	return nil
+}
+
+func (n *LogFileNode) Read(ctx context.Context, h fusefs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	r, ok := h.(io.ReaderAt)
+	if !ok {
+		return nil, syscall.EBADF
+	}
+	c, err := r.ReadAt(dest, off)
+	if err != nil && err != io.EOF {
+		return nil, fusefs.ToErrno(err)
+	}
+	return fuse.ReadResultData(dest[:c]), fusefs.OK
+}
+
+// Release stops following the logs when all handles are released.
+func (n *LogFileNode) Release(ctx context.Context, h fusefs.FileHandle) syscall.Errno {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.refs--
+	if n.refs == 0 {
+		n.cancel()
+		n.logs = nil
+	}
+	return fusefs.OK
+}
+
+// logBuffer is a growing buffer of the logs shared by the handles of the
+// log file.  Offsets are of the whole logs, and the older half of the logs
+// is dropped when the logs exceed maxLogSize.
+type logBuffer struct {
+	mu   sync.Mutex
+	base int64
+	data []byte
+
+	// settled is closed when the current logs are retrieved.
+	settled    chan struct{}
+	settleOnce sync.Once
+}
+
+func newLogBuffer() *logBuffer {
+	return &logBuffer{settled: make(chan struct{})}
+}
+
+func (b *logBuffer) settle() {
+	b.settleOnce.Do(func() { close(b.settled) })
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data = append(b.data, p...)
+	if len(b.data) > maxLogSize {
+		drop := len(b.data) - maxLogSize/2
+		b.data = append([]byte(nil), b.data[drop:]...)
+		b.base += int64(drop)
+	}
+	return len(p), nil
+}
+
+// readAt reads the logs at the offset of the whole logs.  If the logs at the
+// offset are dropped, it reads from the oldest logs kept, and returns the
+// number of the skipped bytes.
+func (b *logBuffer) readAt(dest []byte, off int64) (int, int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var skipped int64
+	if off < b.base {
+		skipped = b.base - off
+		off = b.base
+	}
+	off -= b.base
+	if off >= int64(len(b.data)) {
+		return 0, skipped, io.EOF
+	}
+	c := copy(dest, b.data[off:])
+	if c < len(dest) {
+		return c, skipped, io.EOF
+	}
+	return c, skipped, nil
+}
+
+func (b *logBuffer) size() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.base + int64(len(b.data))
+}
+
+// open returns a handle reading the logs from the oldest logs kept.
+func (b *logBuffer) open() *logHandle {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return &logHandle{logs: b, start: b.base}
+}
+
+// logHandle is the file handle of the log file.  The file of each handle
+// starts at the offset of the whole logs, which moves forward when the logs
+// being read are dropped, so the file is always readable.
+type logHandle struct {
+	logs *logBuffer
+
+	mu    sync.Mutex
+	start int64
+}
+
+func (h *logHandle) ReadAt(dest []byte, off int64) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c, skipped, err := h.logs.readAt(dest, h.start+off)
+	h.start += skipped
+	return c, err
+}
+
+func (h *logHandle) size() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.logs.size() - h.start
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLogFileNode(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "nginx"}},
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:                 "nginx",
+				LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{}},
+			}},
+		},
+	}
+	api := fake.NewSimpleClientset(pod)
+	mnt := mountTestNode(t, NewMetaDirNode(api, func() (*corev1.Pod, string) {
+		return pod, "nginx"
+	}))
+
+	// The fake API returns the same logs for the current and the previous
+	// instance
+	for _, name := range []string{"nginx", "nginx.previous"} {
+		data, err := os.ReadFile(filepath.Join(mnt, logDirName, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "fake logs" {
+			t.Errorf("%s: content = %q, want %q", name, data, "fake logs")
+		}
+	}
+	// The logs are retrieved by a single request for each open
+	var requests int
+	for _, a := range api.Actions() {
+		if a.GetSubresource() == "log" {
+			requests++
+		}
+	}
+	if requests != 2 {
+		t.Errorf("%d requests of the logs, want 2", requests)
+	}
+}
+
+func TestLogBuffer(t *testing.T) {
+	b := newLogBuffer()
+	opened := b.open()
+	// Each chunk of 1 MiB is filled with a distinct byte
+	for i := 0; i < 20; i++ {
+		b.Write(bytes.Repeat([]byte{byte('a' + i)}, 1<<20))
+	}
+	if b.size() != 20<<20 {
+		t.Errorf("size = %d, want %d", b.size(), 20<<20)
+	}
+	if len(b.data) > maxLogSize {
+		t.Errorf("%d bytes are kept, want at most %d bytes", len(b.data), maxLogSize)
+	}
+
+	// The file opened after the logs are dropped starts at the oldest logs
+	// kept, and the file opened before skips the dropped logs
+	oldest := byte('a' + b.base>>20)
+	for name, h := range map[string]*logHandle{"after": b.open(), "before": opened} {
+		dest := make([]byte, 10)
+		c, err := h.ReadAt(dest, 0)
+		if err != nil || c != len(dest) {
+			t.Fatalf("%s: ReadAt: %d, %v", name, c, err)
+		}
+		if !bytes.Equal(dest, bytes.Repeat([]byte{oldest}, len(dest))) {
+			t.Errorf("%s: content = %q, want %q", name, dest, oldest)
+		}
+		if want := int64(len(b.data)); h.size() != want {
+			t.Errorf("%s: size = %d, want %d", name, h.size(), want)
+		}
+		if c, err := h.ReadAt(dest, h.size()-5); c != 5 || err != io.EOF || dest[0] != 'a'+19 {
+			t.Errorf("%s: ReadAt of the end: %d, %v, %q", name, c, err, dest[:c])
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"syscall"
+
+	fusefs "github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// metaDirName is the name of the metadata directory at the root of the
+// mount.
+const metaDirName = ".kubectl-mount"
+
+// metaSource returns the pod and the name of the container whose metadata
+// is shown in the metadata directory.  The pod is nil if no pods are
+// mounted currently.
+type metaSource func() (*corev1.Pod, string)
+
+// metaFile generates the content of the file in the metadata directory from
+// the latest pod and the container.
+type metaFile func(ctx context.Context, api kubernetes.Interface, pod *corev1.Pod, containerName string) ([]byte, error)
+
+var metaFiles = map[string]metaFile{
+	"pod.yaml":     podManifest,
+	"status.yaml":  containerStatus,
+	"env":          containerEnv,
+	"image":        containerImage,
+	"image-digest": containerImageDigest,
+}
+
+// logDirName is the name of the directory containing logs of the
+// containers in the metadata directory.
+const logDirName = "logs"
+
+// MetaDirNode is a read-only directory containing metadata of the pod and
+// the container, such as the manifest and the logs.  The directory is
+// synthesized at the root of the mount, and does not exist on the pod.
+type MetaDirNode struct {
+	fusefs.Inode
+
+	api    kubernetes.Interface
+	source metaSource
+}
+
+var _ = (fusefs.NodeReaddirer)((*MetaDirNode)(nil))
+var _ = (fusefs.NodeLookuper)((*MetaDirNode)(nil))
+var _ = (fusefs.NodeGetattrer)((*MetaDirNode)(nil))
+
+func NewMetaDirNode(api kubernetes.Interface, source metaSource) *MetaDirNode {
+	return &MetaDirNode{
+		api:    api,
+		source: source,
+	}
+}
+
+func (n *MetaDirNode) Readdir(ctx context.Context) (fusefs.DirStream, syscall.Errno) {
+	names := make([]string, 0, len(metaFiles))
+	for name := range metaFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	entries := make([]fuse.DirEntry, len(names))
+	for i, name := range names {
+		entries[i] = fuse.DirEntry{Mode: fuse.S_IFREG, Name: name}
+	}
+	entries = append(entries, fuse.DirEntry{Mode: fuse.S_IFDIR, Name: logDirName})
+	return fusefs.NewListDirStream(entries), 0
+}
+
+func (n *MetaDirNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fusefs.Inode, syscall.Errno) {
+	if name == logDirName {
+		out.Mode = fuse.S_IFDIR | 0555
+		if ch := n.GetChild(logDirName); ch != nil {
+			return ch, fusefs.OK
+		}
+		return n.NewPersistentInode(ctx, &LogDirNode{meta: n}, fusefs.StableAttr{Mode: fuse.S_IFDIR}), fusefs.OK
+	}
+
+	gen, ok := metaFiles[name]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	node := &MetaFileNode{dir: n, gen: gen}
+	out.Mode = fuse.S_IFREG | 0444
+	return n.NewInode(ctx, node, fusefs.StableAttr{Mode: fuse.S_IFREG}), fusefs.OK
+}
+
+func (n *MetaDirNode) Getattr(ctx context.Context, f fusefs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = fuse.S_IFDIR | 0555
+	return fusefs.OK
+}
+
+// latestPod returns the latest state of the mounted pod and the name of the
+// container from the API.
+func (n *MetaDirNode) latestPod(ctx context.Context) (*corev1.Pod, string, error) {
+	cached, containerName := n.source()
+	if cached == nil {
+		return nil, "", syscall.EIO
+	}
+	pod, err := n.api.CoreV1().Pods(cached.Namespace).Get(ctx, cached.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+	return pod, containerName, nil
+}
+
+// MetaFileNode is a file in the metadata directory.  The content is
+// generated when the file is opened.  The size of the file is reported as
+// zero like files in /proc, so the file should be read until EOF.
+type MetaFileNode struct {
+	fusefs.Inode
+
+	dir *MetaDirNode
+	gen metaFile
+}
+
+var _ = (fusefs.NodeGetattrer)((*MetaFileNode)(nil))
+var _ = (fusefs.NodeOpener)((*MetaFileNode)(nil))
+var _ = (fusefs.NodeReader)((*MetaFileNode)(nil))
+
+func (n *MetaFileNode) Getattr(ctx context.Context, f fusefs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = fuse.S_IFREG | 0444
+	if r, ok := f.(*bytes.Reader); ok {
+		out.Size = uint64(r.Size())
+	}
+	return fusefs.OK
+}
+
+func (n *MetaFileNode) Open(ctx context.Context, flags uint32) (fusefs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	pod, containerName, err := n.dir.latestPod(ctx)
+	if err != nil {
+		return nil, 0, syscall.EIO
+	}
+	data, err := n.gen(ctx, n.dir.api, pod, containerName)
+	if err != nil {
+		return nil, 0, fusefs.ToErrno(err)
+	}
+	return bytes.NewReader(data), fuse.FOPEN_DIRECT_IO, fusefs.OK
+}
+
+func (n *MetaFileNode) Read(ctx context.Context, h fusefs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	r, ok := h.(io.ReaderAt)
+	if !ok {
+		return nil, syscall.EBADF
+	}
+	c, err := r.ReadAt(dest, off)
+	if err != nil && err != io.EOF {
+		return nil, fusefs.ToErrno(err)
+	}
+	return fuse.ReadResultData(dest[:c]), fusefs.OK
+}
+
+func podManifest(ctx context.Context, api kubernetes.Interface, pod *corev1.Pod, containerName string) ([]byte, error) {
+	pod = pod.DeepCopy()
+	pod.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Pod"))
+	pod.ManagedFields = nil
+	return yaml.Marshal(pod)
+}
+
+func containerStatus(ctx context.Context, api kubernetes.Interface, pod *corev1.Pod, containerName string) ([]byte, error) {
+	s := findContainerStatus(pod, containerName)
+	if s == nil {
+		return nil, syscall.ENOENT
+	}
+	return yaml.Marshal(s)
+}
+
+// containerEnv returns environment variables of the container in the spec
+// as NAME=value lines.  Variables from ConfigMaps, Secrets and fields are
+// shown as comments since their values are not resolved.
+func containerEnv(ctx context.Context, api kubernetes.Interface, pod *corev1.Pod, containerName string) ([]byte, error) {
+	c := findContainer(pod, containerName)
+	if c == nil {
+		return nil, syscall.ENOENT
+	}
+	var buf bytes.Buffer
+	for _, from := range c.EnvFrom {
+		switch {
+		case from.ConfigMapRef != nil:
+			fmt.Fprintf(&buf, "# %s* from configmap/%s\n", from.Prefix, from.ConfigMapRef.Name)
+		case from.SecretRef != nil:
+			fmt.Fprintf(&buf, "# %s* from secret/%s\n", from.Prefix, from.SecretRef.Name)
+		}
+	}
+	for _, env := range c.Env {
+		from := env.ValueFrom
+		switch {
+		case from == nil:
+			fmt.Fprintf(&buf, "%s=%s\n", env.Name, env.Value)
+		case from.ConfigMapKeyRef != nil:
+			fmt.Fprintf(&buf, "# %s from configmap/%s key %s\n", env.Name, from.ConfigMapKeyRef.Name, from.ConfigMapKeyRef.Key)
+		case from.SecretKeyRef != nil:
+			fmt.Fprintf(&buf, "# %s from secret/%s key %s\n", env.Name, from.SecretKeyRef.Name, from.SecretKeyRef.Key)
+		case from.FieldRef != nil:
+			fmt.Fprintf(&buf, "# %s from field %s\n", env.Name, from.FieldRef.FieldPath)
+		case from.ResourceFieldRef != nil:
+			fmt.Fprintf(&buf, "# %s from resource %s\n", env.Name, from.ResourceFieldRef.Resource)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func containerImage(ctx context.Context, api kubernetes.Interface, pod *corev1.Pod, containerName string) ([]byte, error) {
+	c := findContainer(pod, containerName)
+	if c == nil {
+		return nil, syscall.ENOENT
+	}
+	return []byte(c.Image + "\n"), nil
+}
+
+// containerImageDigest returns the image ID resolved by the container
+// runtime, such as docker.io/library/nginx@sha256:...
+func containerImageDigest(ctx context.Context, api kubernetes.Interface, pod *corev1.Pod, containerName string) ([]byte, error) {
+	s := findContainerStatus(pod, containerName)
+	if s == nil || s.ImageID == "" {
+		return nil, syscall.ENOENT
+	}
+	return []byte(s.ImageID + "\n"), nil
+}
+
+// findContainer returns the spec of the container in the pod, including
+// init containers and ephemeral containers.
+func findContainer(pod *corev1.Pod, name string) *corev1.Container {
+	for i := range pod.Spec.InitContainers {
+		if c := &pod.Spec.InitContainers[i]; c.Name == name {
+			return c
+		}
+	}
+	for i := range pod.Spec.Containers {
+		if c := &pod.Spec.Containers[i]; c.Name == name {
+			return c
+		}
+	}
+	for i := range pod.Spec.EphemeralContainers {
+		if c := &pod.Spec.EphemeralContainers[i]; c.Name == name {
+			common := corev1.Container(c.EphemeralContainerCommon)
+			return &common
+		}
+	}
+	return nil
+}
+
+// findContainerStatus returns the status of the container in the pod,
+// including init containers and ephemeral containers.
+func findContainerStatus(pod *corev1.Pod, name string) *corev1.ContainerStatus {
+	for _, statuses := range [][]corev1.ContainerStatus{
+		pod.Status.InitContainerStatuses,
+		pod.Status.ContainerStatuses,
+		pod.Status.EphemeralContainerStatuses,
+	} {
+		for i := range statuses {
+			if statuses[i].Name == name {
+				return &statuses[i]
+			}
+		}
+	}
+	return nil
+}
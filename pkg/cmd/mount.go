@@ -4,11 +4,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
+	"os/exec"
 	"os/signal"
+	"path"
+	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
 	fusefs "github.com/hanwen/go-fuse/v2/fs"
 	"github.com/spf13/cobra"
@@ -16,32 +21,96 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/printers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	restclient "k8s.io/client-go/rest"
 )
 
 const (
-	mountUsageStr = "mount [user@]pod:[dir] mountpoint"
+	mountUsageStr = "mount [user@](pod|type/name):[dir] mountpoint"
 
 	mountExample = `
 	# Mount a remote filesystem of default container on the pod nginx
 	kubectl mount nginx:/etc /tmp/nginx/etc
 
 	# Mount a remote filesystem of side-car container on the pod nginx
-	kubectl mount -c sidecar nginx:/etc /tmp/sidecar/etc`
+	kubectl mount -c sidecar nginx:/etc /tmp/sidecar/etc
+
+	# Mount a remote filesystem of a pod of the deployment nginx
+	kubectl mount deploy/nginx:/etc /tmp/nginx/etc
+
+	# Mount a remote filesystem of a pod selected by the label
+	kubectl mount -l app=nginx :/etc /tmp/nginx/etc
+
+	# Mount remote filesystems of all pods of the deployment nginx as subdirectories
+	kubectl mount --all-pods deploy/nginx:/etc /tmp/nginx
+
+	# Mount remote filesystems of all containers on the pod nginx as subdirectories
+	kubectl mount --all-containers nginx:/etc /tmp/nginx
+
+	# Mount a remote filesystem as the user www-data
+	kubectl mount www-data@nginx:/var/www /tmp/nginx/www
+
+	# Mount a remote filesystem as read-write
+	kubectl mount --rw nginx:/etc/nginx /tmp/nginx/etc
+
+	# Mount a remote filesystem of the distroless container via an ephemeral container
+	kubectl mount --ephemeral distroless:/etc /tmp/distroless/etc
+
+	# Mount a remote filesystem via the helper agent copied into the container
+	kubectl mount --backend=agent --agent-binary=./kubectl-mount-agent nginx:/etc /tmp/nginx/etc
+
+	# Mount a remote filesystem in the background, and unmount it later
+	kubectl mount --background nginx:/etc /tmp/nginx/etc
+	kubectl mount umount /tmp/nginx/etc`
+)
+
+const (
+	backendExec  = "exec"
+	backendAgent = "agent"
+
+	defaultAgentBinary = "kubectl-mount-agent"
+	defaultAgentPath   = "/tmp/kubectl-mount-agent"
+
+	defaultEphemeralImage = "busybox"
 )
 
 type MountOptions struct {
 	configFlags *genericclioptions.ConfigFlags
 
-	User          string
-	PodName       string
-	RemoteDir     string
-	MountPoint    string
-	Namespace     string
-	ContainerName string
-	Debug         bool
+	User              string
+	PodName           string
+	RemoteDir         string
+	MountPoint        string
+	Namespace         string
+	ContainerName     string
+	Selector          string
+	PodRunningTimeout time.Duration
+	ReadWrite         bool
+	AttrTimeout       time.Duration
+	EntryTimeout      time.Duration
+	Backend           string
+	AgentBinary       string
+	AgentPath         string
+	Ephemeral         bool
+	EphemeralImage    string
+	AllPods           bool
+	AllContainers     bool
+	Background        bool
+	Watch             bool
+	Xattrs            bool
+	PrintCapabilities bool
+	LazyUnmount       bool
+	Debug             bool
+	ExecProtocol      string
+	Executor          string
+	LocalRoot         string
+	LocalPID          int
+
+	clientConfig *restclient.Config
+	api          kubernetes.Interface
+	restClient   *restclient.RESTClient
 
 	genericclioptions.IOStreams
 }
@@ -64,6 +133,7 @@ func NewCmdMount(streams genericclioptions.IOStreams) *cobra.Command {
 		Use:          mountUsageStr,
 		Short:        "Mount a remote filesystem on the pods",
 		Example:      mountExample,
+		Args:         cobra.ArbitraryArgs,
 		SilenceUsage: true,
 		RunE: func(c *cobra.Command, args []string) error {
 			if err := o.Complete(c, args); err != nil {
@@ -78,18 +148,53 @@ func NewCmdMount(streams genericclioptions.IOStreams) *cobra.Command {
 	}
 
 	cmd.Flags().StringVarP(&o.ContainerName, "container", "c", "", "Container name. If omitted, use the kubectl.kubernetes.io/default-container annotation for selecting the container to be attached or the first container in the pod will be chosen")
+	cmd.Flags().StringVarP(&o.Selector, "selector", "l", "", "Selector (label query) to select a pod to mount")
+	cmd.Flags().DurationVar(&o.PodRunningTimeout, "pod-running-timeout", time.Minute, "The length of time (like 5s, 2m, or 3h, higher than zero) to wait until at least one pod is running")
+	cmd.Flags().BoolVar(&o.ReadWrite, "rw", false, "Mount the remote filesystem as read-write. Files are replaced atomically on the pod when they are closed")
+	cmd.Flags().DurationVar(&o.AttrTimeout, "attr-timeout", time.Second, "Duration to cache attributes of files and targets of symlinks. Zero disables the cache")
+	cmd.Flags().DurationVar(&o.EntryTimeout, "entry-timeout", time.Second, "Duration to cache entries in directories. Zero disables the cache")
+	cmd.Flags().StringVar(&o.Backend, "backend", backendExec, "Backend to access files on the container. One of: exec|agent. The exec backend runs commands in the container, and the agent backend copies the helper agent into the container")
+	cmd.Flags().StringVar(&o.AgentBinary, "agent-binary", "", "Path to the kubectl-mount-agent binary for the container. If omitted, it is searched from the directory of kubectl-mount and the PATH")
+	cmd.Flags().StringVar(&o.AgentPath, "agent-path", defaultAgentPath, "Path in the container to install the agent")
+	cmd.Flags().BoolVar(&o.Ephemeral, "ephemeral", false, "Access files of the container via an ephemeral container sharing the process namespace. It is useful for containers without any commands, such as distroless images")
+	cmd.Flags().StringVar(&o.EphemeralImage, "ephemeral-image", defaultEphemeralImage, "Container image of the ephemeral container")
+	cmd.Flags().BoolVar(&o.AllPods, "all-pods", false, "Mount all running pods selected by the workload reference or the selector. Each pod appears as a directory named after the pod, and pods are added and removed as they start and stop")
+	cmd.Flags().BoolVar(&o.AllContainers, "all-containers", false, "Mount all running containers in the pod, including init and ephemeral containers. Each container appears as a directory named after the container")
+	cmd.Flags().BoolVar(&o.Watch, "watch", false, "Watch changes of files in the container, and drop the cached attributes and contents of the changed files. It requires inotifywait in the container with the exec backend")
+	cmd.Flags().BoolVar(&o.Xattrs, "xattrs", false, "Read extended attributes of files in the container. It requires getfattr in the container with the exec backend. The user.k8s.* attributes of the pod are available without it")
+	cmd.Flags().BoolVar(&o.PrintCapabilities, "print-capabilities", false, "Print the commands detected in the container and the commands used for each operation, and exit without mounting")
+	cmd.Flags().BoolVar(&o.Background, "background", false, "Run in the background after the filesystem is mounted. Active mounts are listed by 'kubectl mount list' and unmounted by 'kubectl mount umount'")
+	cmd.Flags().BoolVar(&o.LazyUnmount, "lazy-unmount", false, "Detach the filesystem lazily on shutdown if it is busy. The command exits with code 3 after the processes close the files")
 	cmd.Flags().BoolVar(&o.Debug, "debug", false, "Print fuse debug log if true")
+	cmd.Flags().StringVar(&o.ExecProtocol, "exec-protocol", execProtocolAuto, "Protocol to run commands in the container. One of: auto|websocket|spdy. The auto protocol uses WebSocket, and falls back to SPDY if the API server does not support it")
+	cmd.Flags().StringVar(&o.Executor, "executor", executorKubernetes, "Executor to run commands. One of: kubernetes|local. The local executor runs commands on the local machine instead of the pod for development")
+	cmd.Flags().StringVar(&o.LocalRoot, "local-root", "", "Root directory to run commands by chroot with --executor=local")
+	cmd.Flags().IntVar(&o.LocalPID, "local-pid", 0, "Process to run commands in its namespaces by nsenter with --executor=local")
+	for _, name := range []string{"executor", "local-root", "local-pid"} {
+		_ = cmd.Flags().MarkHidden(name)
+	}
 	o.configFlags.AddFlags(cmd.PersistentFlags())
 
+	cmd.AddCommand(NewCmdList(streams))
+	cmd.AddCommand(NewCmdUmount(streams))
+
 	return cmd
 }
 
 func (o *MountOptions) Complete(c *cobra.Command, args []string) error {
+	if o.PrintCapabilities && len(args) == 1 {
+		// The mountpoint is not needed to print capabilities
+		args = append(args, ".")
+	}
 	if len(args) != 2 {
 		return errors.New("remote filesystem and mountpoint is required")
 	}
 
 	remote, mountpoint := args[0], args[1]
+	mountpoint, err := filepath.Abs(mountpoint)
+	if err != nil {
+		return err
+	}
 	o.MountPoint = mountpoint
 
 	if !strings.Contains(remote, ":") {
@@ -109,10 +214,56 @@ func (o *MountOptions) Complete(c *cobra.Command, args []string) error {
 	} else {
 		o.PodName = sp[0]
 	}
-	if o.PodName == "" {
+	if o.Selector != "" && o.PodName != "" {
+		return errors.New("the pod name and the selector cannot be specified at the same time")
+	}
+	if o.Selector == "" && o.PodName == "" {
 		return fmt.Errorf("expected '%s'. The pod name is empty", mountUsageStr)
 	}
 
+	if o.AllContainers && o.ContainerName != "" {
+		return errors.New("the container name and --all-containers cannot be specified at the same time")
+	}
+	if o.AllPods && o.Selector == "" && !strings.Contains(o.PodName, "/") {
+		return errors.New("--all-pods requires a workload reference such as deploy/nginx or a selector")
+	}
+
+	switch o.Backend {
+	case backendExec:
+	case backendAgent:
+		if o.AgentBinary == "" {
+			bin, err := findAgentBinary()
+			if err != nil {
+				return err
+			}
+			o.AgentBinary = bin
+		}
+	default:
+		return fmt.Errorf("unknown backend %q. It should be one of: %s|%s", o.Backend, backendExec, backendAgent)
+	}
+
+	switch o.ExecProtocol {
+	case execProtocolAuto, execProtocolWebSocket, execProtocolSPDY:
+	default:
+		return fmt.Errorf("unknown exec protocol %q. It should be one of: %s|%s|%s", o.ExecProtocol, execProtocolAuto, execProtocolWebSocket, execProtocolSPDY)
+	}
+
+	switch o.Executor {
+	case executorKubernetes:
+		if o.LocalRoot != "" || o.LocalPID != 0 {
+			return errors.New("--local-root and --local-pid require --executor=local")
+		}
+	case executorLocal:
+		if o.Selector != "" || o.AllPods || o.AllContainers || o.Ephemeral {
+			return errors.New("--selector, --all-pods, --all-containers and --ephemeral cannot be used with --executor=local")
+		}
+		if o.LocalRoot != "" && o.LocalPID != 0 {
+			return errors.New("--local-root and --local-pid cannot be specified at the same time")
+		}
+	default:
+		return fmt.Errorf("unknown executor %q. It should be one of: %s|%s", o.Executor, executorKubernetes, executorLocal)
+	}
+
 	namespace, _, err := o.configFlags.ToRawKubeConfigLoader().Namespace()
 	if err != nil {
 		return err
@@ -124,6 +275,132 @@ func (o *MountOptions) Complete(c *cobra.Command, args []string) error {
 
 // Run mounts a pod or pods on the resources
 func (o *MountOptions) RunMount(ctx context.Context) error {
+	if o.Background && !inBackground() && !o.PrintCapabilities {
+		return o.runBackground()
+	}
+
+	if o.Executor == executorKubernetes {
+		if err := o.setupClients(); err != nil {
+			return err
+		}
+	}
+
+	if o.PrintCapabilities {
+		return o.printCapabilities(ctx)
+	}
+
+	var root fusefs.InodeEmbedder
+	var source, container string
+	if o.AllPods {
+		selector, pod, err := o.resolveSelector()
+		if err != nil {
+			return err
+		}
+		if pod != nil {
+			return fmt.Errorf("--all-pods requires a workload reference or a selector, but got pod %s", pod.Name)
+		}
+
+		dir := NewDirNode()
+		defer dir.Close()
+		go watchPods(ctx, o.api, o.Namespace, metav1.ListOptions{LabelSelector: selector.String()}, podHandler{
+			Running: func(pod *corev1.Pod) {
+				dir.Add(pod.Name, func() (fusefs.InodeEmbedder, func(), error) {
+					return o.newNode(ctx, pod)
+				})
+			},
+			Stopped: func(pod *corev1.Pod) {
+				dir.Remove(pod.Name)
+			},
+		})
+		root = dir
+		source = "pods matching " + selector.String()
+		container = o.ContainerName
+	} else {
+		pod, err := o.resolveTarget(ctx)
+		if err != nil {
+			return err
+		}
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			return fmt.Errorf("cannot mount filesystem on the container in a completed pod; current phase is %s", pod.Status.Phase)
+		}
+
+		newNode := o.newFollowingNode
+		if o.AllContainers || o.Executor == executorLocal {
+			// The local machine is not replaced like pods
+			newNode = o.newNode
+		}
+		node, release, err := newNode(ctx, pod)
+		if err != nil {
+			return err
+		}
+		defer release()
+		root = node
+		source = pod.Name
+		if !o.AllContainers {
+			container = o.containerFor(pod)
+		}
+	}
+
+	var opt fusefs.Options
+	opt.Debug = o.Debug
+	opt.AttrTimeout = &o.AttrTimeout
+	opt.EntryTimeout = &o.EntryTimeout
+	if !o.ReadWrite {
+		opt.MountOptions.Options = append(opt.MountOptions.Options, "ro")
+	}
+	srv, err := fusefs.Mount(o.MountPoint, root, &opt)
+	if err != nil {
+		log.Fatalf("Mount fail: %v\n", err)
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM)
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- o.unmountOnSignal(srv, ch)
+	}()
+	served := make(chan struct{})
+	go func() {
+		srv.Wait()
+		close(served)
+	}()
+
+	state := &MountState{
+		PID:        os.Getpid(),
+		Namespace:  o.Namespace,
+		Pod:        source,
+		Container:  container,
+		RemoteDir:  o.RemoteDir,
+		MountPoint: o.MountPoint,
+		Background: inBackground(),
+		Started:    time.Now(),
+	}
+	if err := saveMountState(state); err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to record the mount:", err)
+	}
+	defer removeMountState(state.PID)
+
+	msg := fmt.Sprintf("Mounted %s:%s on %s", source, o.RemoteDir, o.MountPoint)
+	fmt.Fprintln(os.Stderr, msg)
+	notifyMounted(msg)
+
+	select {
+	case err := <-shutdown:
+		var exitErr *ExitError
+		if errors.As(err, &exitErr) && exitErr.Code == ExitCodeBusy {
+			return err
+		}
+		<-served
+		return err
+	case <-served:
+		// The filesystem is unmounted by another process, such as
+		// kubectl mount umount
+		return nil
+	}
+}
+
+// setupClients creates the clients of the Kubernetes API.
+func (o *MountOptions) setupClients() error {
 	clientConfig, err := o.configFlags.ToRESTConfig()
 	if err != nil {
 		return err
@@ -132,67 +409,281 @@ func (o *MountOptions) RunMount(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	o.clientConfig = clientConfig
 
-	api, err := kubernetes.NewForConfig(clientConfig)
+	o.api, err = kubernetes.NewForConfig(clientConfig)
 	if err != nil {
 		return err
 	}
-	pod, err := api.CoreV1().Pods(o.Namespace).Get(ctx, o.PodName, metav1.GetOptions{})
-	if err != nil {
-		return err
+	o.restClient, err = restclient.RESTClientFor(clientConfig)
+	return err
+}
+
+// resolveTarget returns the pod to mount, or the pod standing for the local
+// machine with --executor=local.
+func (o *MountOptions) resolveTarget(ctx context.Context) (*corev1.Pod, error) {
+	if o.Executor == executorLocal {
+		return localPod(o.PodName, o.Namespace), nil
+	}
+	return o.resolvePod(ctx, o.api)
+}
+
+// newFollowingNode returns a root node of the filesystem on the container in
+// the pod, and a function to release it.  The filesystem follows the
+// replacement pod when the pod is deleted or the container is restarted.
+func (o *MountOptions) newFollowingNode(ctx context.Context, pod *corev1.Pod) (fusefs.InodeEmbedder, func(), error) {
+	selectors := metav1.ListOptions{LabelSelector: o.Selector}
+	if o.Selector == "" {
+		var err error
+		selectors, err = replacementSelectors(ctx, o.api, pod)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	sfs := &SwitchFS{}
+	fsys := NewCachedFS(sfs, o.AttrTimeout, o.EntryTimeout)
+	f := newPodFollower(ctx, o, sfs, fsys)
+	if err := f.Start(pod); err != nil {
+		return nil, nil, err
+	}
+	go watchPods(ctx, o.api, o.Namespace, selectors, f.Handler())
+
+	return &PodFuseNode{
+		fsys:     fsys,
+		writable: o.ReadWrite,
+		xattrs:   o.Xattrs,
+		source:   f.Current,
+		meta:     NewMetaDirNode(o.api, f.Current),
+		watch:    o.newChangeWatcher(ctx, sfs),
+	}, sfs.Close, nil
+}
+
+// newNode returns a root node of the pod.  The node contains directories of
+// the running containers with --all-containers.
+func (o *MountOptions) newNode(ctx context.Context, pod *corev1.Pod) (fusefs.InodeEmbedder, func(), error) {
+	if !o.AllContainers {
+		return o.newPodNode(ctx, pod, o.containerFor(pod))
 	}
 
-	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
-		return fmt.Errorf("cannot mount filesystem on the container in a completed pod; current phase is %s", pod.Status.Phase)
+	// Get the latest status of the containers
+	pod, err := o.api.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	dir := NewDirNode()
+	for _, name := range runningContainers(pod) {
+		name := name
+		dir.Add(name, func() (fusefs.InodeEmbedder, func(), error) {
+			return o.newPodNode(ctx, pod, name)
+		})
 	}
+	return dir, dir.Close, nil
+}
 
-	containerName := o.ContainerName
-	if containerName == "" {
-		containerName = pod.Spec.Containers[0].Name
+// newPodNode returns a root node of the filesystem on the container in the
+// pod, and a function to release it.
+func (o *MountOptions) newPodNode(ctx context.Context, pod *corev1.Pod, containerName string) (*PodFuseNode, func(), error) {
+	backend, release, err := o.newBackend(ctx, pod, containerName)
+	if err != nil {
+		return nil, nil, err
 	}
+	source := func() (*corev1.Pod, string) {
+		return pod, containerName
+	}
+	node := &PodFuseNode{
+		fsys:     NewCachedFS(backend, o.AttrTimeout, o.EntryTimeout),
+		writable: o.ReadWrite,
+		xattrs:   o.Xattrs,
+		source:   source,
+		watch:    o.newChangeWatcher(ctx, backend),
+	}
+	if o.AllPods || o.AllContainers {
+		// Pods and containers from the same image share inode numbers
+		node.root = newRootID()
+	}
+	if o.api != nil {
+		// The metadata is read from the API, which is not available with
+		// --executor=local
+		node.meta = NewMetaDirNode(o.api, source)
+	}
+	return node, release, nil
+}
 
-	restClient, err := restclient.RESTClientFor(clientConfig)
+// printCapabilities prints the capabilities of the container to mount.
+func (o *MountOptions) printCapabilities(ctx context.Context) error {
+	pod, err := o.resolveTarget(ctx)
 	if err != nil {
 		return err
 	}
+	containerName := o.containerFor(pod)
+	e, _, _, err := o.newExecutor(ctx, pod, containerName)
+	if err != nil {
+		return err
+	}
+	caps, err := ProbeCapabilities(ctx, e)
+	if err != nil {
+		return fmt.Errorf("unable to detect commands in the container: %w", err)
+	}
+	w := printers.GetNewTabWriter(o.Out)
+	fmt.Fprintf(w, "Pod:\t%s\n", pod.Name)
+	fmt.Fprintf(w, "Container:\t%s\n", containerName)
+	caps.Print(w)
+	return w.Flush()
+}
+
+// newChangeWatcher returns a watcher of changes of files on the filesystem
+// with --watch, or nil.
+func (o *MountOptions) newChangeWatcher(ctx context.Context, fsys fs.FS) *changeWatcher {
+	if !o.Watch {
+		return nil
+	}
+	wfs, ok := fsys.(WatchFS)
+	if !ok {
+		return nil
+	}
+	return &changeWatcher{ctx: ctx, fsys: wfs}
+}
+
+// containerFor returns the name of the container to mount in the pod.  The
+// first container is used if the container name is not specified.
+func (o *MountOptions) containerFor(pod *corev1.Pod) string {
+	if o.ContainerName != "" {
+		return o.ContainerName
+	}
+	return pod.Spec.Containers[0].Name
+}
+
+// newExecutor returns an executor running commands as the user in the
+// container, an executor as the default user, and the directory to mount in
+// the executor.
+func (o *MountOptions) newExecutor(ctx context.Context, pod *corev1.Pod, containerName string) (Executor, Executor, string, error) {
+	if o.Executor == executorLocal {
+		var e Executor = &LocalExecutor{Root: o.LocalRoot, PID: o.LocalPID}
+		ue, err := o.newUserExecutor(ctx, e, "/")
+		if err != nil {
+			return nil, nil, "", err
+		}
+		return ue, e, o.RemoteDir, nil
+	}
 
 	e := &PodExecutor{
 		Namespace:     pod.GetNamespace(),
 		PodName:       pod.GetName(),
 		ContainerName: containerName,
-		Config:        clientConfig,
-		RestClient:    restClient,
+		Config:        o.clientConfig,
+		RestClient:    o.restClient,
+		Protocol:      o.ExecProtocol,
 	}
 
-	fsys := &PodFS{
-		Executor: e,
-		Pwd:      o.RemoteDir,
+	targetRoot := "/"
+	remoteDir := o.RemoteDir
+	if o.Ephemeral {
+		name, err := ensureEphemeralContainer(ctx, o.api, pod, containerName, o.EphemeralImage, o.PodRunningTimeout)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		e.ContainerName = name
+		// Get the latest ID of the target container, which changes on
+		// restarts
+		pod, err := o.api.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, "", err
+		}
+		id, err := containerID(pod, containerName)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		targetRoot, err = findTargetRoot(ctx, e, id)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		remoteDir = path.Join(targetRoot, o.RemoteDir)
+		fmt.Fprintf(os.Stderr, "Using ephemeral container %s targeting %s\n", name, containerName)
 	}
-	root := &PodFuseNode{
-		fsys: fsys,
+
+	ue, err := o.newUserExecutor(ctx, e, targetRoot)
+	if err != nil {
+		return nil, nil, "", err
 	}
+	return ue, e, remoteDir, nil
+}
 
-	var opt fusefs.Options
-	opt.Debug = o.Debug
-	opt.MountOptions.Options = append(opt.MountOptions.Options, "ro")
-	srv, err := fusefs.Mount(o.MountPoint, root, &opt)
+// newUserExecutor returns an executor running commands as the user, or the
+// executor itself if the user is not specified.  The user is looked up in
+// the root directory of the container.
+func (o *MountOptions) newUserExecutor(ctx context.Context, e Executor, targetRoot string) (Executor, error) {
+	if o.User == "" {
+		return e, nil
+	}
+	u, err := LookupUser(ctx, e, path.Join(targetRoot, "/etc/passwd"), o.User)
 	if err != nil {
-		log.Fatalf("Mount fail: %v\n", err)
+		return nil, err
 	}
+	return NewUserExecutor(ctx, e, u)
+}
 
-	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM)
-	go func() {
-		<-ch
-		err = srv.Unmount()
+// newBackend returns a filesystem on the container, and a function to
+// release it.
+func (o *MountOptions) newBackend(ctx context.Context, pod *corev1.Pod, containerName string) (fs.FS, func(), error) {
+	ue, e, remoteDir, err := o.newExecutor(ctx, pod, containerName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	release := func() {}
+	var backend fs.FS
+	switch o.Backend {
+	case backendExec:
+		caps, err := ProbeCapabilities(ctx, ue)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "Unable to unmount:", err)
+			return nil, nil, fmt.Errorf("unable to detect commands in the container: %w. Use --backend=agent or --ephemeral for the container without commands", err)
 		}
-	}()
-	fmt.Fprintf(os.Stderr, "Mounted %s:%s on %s\n", o.PodName, o.RemoteDir, o.MountPoint)
-	srv.Wait()
+		backend = &PodFS{
+			Executor:     ue,
+			Pwd:          remoteDir,
+			Capabilities: caps,
+		}
+	case backendAgent:
+		if !o.Ephemeral {
+			// The agent is installed by sh, which distroless images do
+			// not have, while the ephemeral container has
+			caps, err := ProbeCapabilities(ctx, e)
+			if err != nil {
+				return nil, nil, fmt.Errorf("unable to detect commands in the container: %w. Use --ephemeral to install the agent in the container without sh", err)
+			}
+			if !caps.Shell {
+				return nil, nil, errors.New("the agent cannot be installed in the container without sh. Use --ephemeral to install the agent in an ephemeral container")
+			}
+		}
+		err := InstallAgent(ctx, e, o.AgentBinary, o.AgentPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		client := StartAgent(ctx, ue, []string{o.AgentPath})
+		release = func() { client.Close() }
+		backend = &AgentFS{
+			Client: client,
+			Pwd:    remoteDir,
+		}
+	}
+	return backend, release, nil
+}
 
-	return nil
+// findAgentBinary finds the agent binary from the directory of the executable
+// and the PATH.
+func findAgentBinary() (string, error) {
+	if exe, err := os.Executable(); err == nil {
+		bin := filepath.Join(filepath.Dir(exe), defaultAgentBinary)
+		if _, err := os.Stat(bin); err == nil {
+			return bin, nil
+		}
+	}
+	bin, err := exec.LookPath(defaultAgentBinary)
+	if err != nil {
+		return "", fmt.Errorf("%s is not found. Specify the path by --agent-binary", defaultAgentBinary)
+	}
+	return bin, nil
 }
 
 // See https://github.com/kubernetes/kubernetes/blob/10988997f225447f89841bac08e8848852d7cb55/staging/src/k8s.io/kubectl/pkg/cmd/util/kubectl_match_version.go#L115
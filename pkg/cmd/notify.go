@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// WatchFS is the interface implemented by a file system which can watch
+// changes of files on it.
+type WatchFS interface {
+	fs.FS
+
+	// Watch calls changed with paths of the changed files relative to the
+	// root of the filesystem until the context is done or watching fails.
+	Watch(ctx context.Context, changed func(name string)) error
+}
+
+// watchScript runs inotifywait to print paths of changed files in the
+// directory, and stops it when the stdin is closed.  The paths are
+// terminated by NUL since file names may contain newlines, which requires
+// --no-newline of inotifywait.
+const watchScript = `case "$(inotifywait --help 2>&1)" in
+*--no-newline*) ;;
+*) echo "inotifywait does not support --no-newline" >&2; exit 1 ;;
+esac
+inotifywait -m -r -q -e close_write,attrib,create,delete,moved_from,moved_to --no-newline --format '%w%f%0' "$1" &
+pid=$!
+cat >/dev/null
+kill $pid`
+
+// Watch watches changes of files by inotifywait in the container over a
+// long-lived exec stream.
+func (f *PodFS) Watch(ctx context.Context, changed func(name string)) error {
+	if f.capabilities().watchStrategy() == "" {
+		return errors.New("watching requires sh and inotifywait in the container")
+	}
+	stdin, stop := io.Pipe()
+	r, stdout := io.Pipe()
+	go func() {
+		err := f.Executor.RunStream(ctx, []string{"sh", "-c", watchScript, "sh", f.Pwd}, stdin, stdout)
+		stdout.CloseWithError(err)
+	}()
+	go func() {
+		<-ctx.Done()
+		stop.Close()
+	}()
+
+	s := bufio.NewScanner(r)
+	s.Split(scanNulTerminated)
+	for s.Scan() {
+		if name, ok := relativePath(f.Pwd, s.Text()); ok {
+			changed(name)
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	return errors.New("inotifywait exited")
+}
+
+// scanNulTerminated is a split function for bufio.Scanner to split the input
+// into NUL-terminated strings.
+func scanNulTerminated(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// relativePath returns the path relative to the directory, or false if the
+// path is not in the directory.
+func relativePath(dir, p string) (string, bool) {
+	dir, p = path.Clean(dir), path.Clean(p)
+	if p == dir {
+		return ".", true
+	}
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	if !strings.HasPrefix(p, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(p, prefix), true
+}
+
+// changeWatcher watches changes of files on the filesystem of the root node.
+// Watching starts when the root node is added to the mounted tree, since the
+// kernel cannot be notified before the filesystem is mounted.
+type changeWatcher struct {
+	ctx  context.Context
+	fsys WatchFS
+	once sync.Once
+}
+
+func (w *changeWatcher) start(root *PodFuseNode) {
+	w.once.Do(func() {
+		go func() {
+			err := w.fsys.Watch(w.ctx, root.notifyChange)
+			if err != nil && w.ctx.Err() == nil {
+				fmt.Fprintln(os.Stderr, "Unable to watch changes:", err)
+			}
+		}()
+	})
+}
+
+// notifyChange invalidates the cached metadata of the changed file, and
+// notifies the kernel to drop the cached entry and content of the file.
+func (n *PodFuseNode) notifyChange(name string) {
+	if c, ok := n.fsys.(*CachedFS); ok {
+		c.Invalidate(name)
+	}
+	if name == "." {
+		n.NotifyContent(0, 0)
+		return
+	}
+
+	parent := n.EmbeddedInode()
+	dir, base := path.Split(name)
+	for _, elem := range strings.Split(dir, "/") {
+		if elem == "" {
+			continue
+		}
+		if parent = parent.GetChild(elem); parent == nil {
+			// The kernel does not cache the directory
+			return
+		}
+	}
+	if ch := parent.GetChild(base); ch != nil {
+		ch.NotifyContent(0, 0)
+	}
+	parent.NotifyEntry(base)
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// watchExecutor is an executor printing the output of inotifywait to the
+// stream.
+type watchExecutor struct {
+	Executor
+	output string
+}
+
+func (e *watchExecutor) RunStream(ctx context.Context, command []string, stdin io.Reader, stdout io.Writer) error {
+	_, err := io.WriteString(stdout, e.output)
+	return err
+}
+
+func TestPodFSWatch(t *testing.T) {
+	e := &watchExecutor{output: "/root/a\x00/root/dir/with\nnewline\x00/root\x00/other\x00"}
+	f := &PodFS{
+		Executor:     e,
+		Pwd:          "/root",
+		Capabilities: &Capabilities{Shell: true, Commands: map[string]bool{"inotifywait": true}},
+	}
+	var changed []string
+	err := f.Watch(context.Background(), func(name string) {
+		changed = append(changed, name)
+	})
+	if err == nil {
+		t.Error("Watch returned no errors after inotifywait exited")
+	}
+	if want := []string{"a", "dir/with\nnewline", "."}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("changed = %q, want %q", changed, want)
+	}
+}
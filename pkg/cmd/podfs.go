@@ -1,7 +1,6 @@
 package cmd
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"errors"
@@ -10,6 +9,7 @@ import (
 	"io/fs"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -31,123 +31,162 @@ const (
 type PodDirEntry struct {
 	name string
 	mode fs.FileMode
-	fs   fs.StatFS
+	info *PodFileInfo
 }
 
 func (e *PodDirEntry) Name() string               { return e.name }
 func (e *PodDirEntry) IsDir() bool                { return e.mode.IsDir() }
-func (e *PodDirEntry) Type() fs.FileMode          { return e.mode }
-func (e *PodDirEntry) Info() (fs.FileInfo, error) { return e.fs.Stat(e.name) }
+func (e *PodDirEntry) Type() fs.FileMode          { return e.mode.Type() }
+func (e *PodDirEntry) Info() (fs.FileInfo, error) { return e.info, nil }
 
 type PodFS struct {
 	Executor Executor
 	Pwd      string
+
+	// Capabilities is the userland of the container to select commands.
+	// The GNU userland is assumed if it is nil.
+	Capabilities *Capabilities
 }
 
-func (f *PodFS) Open(name string) (fs.File, error) {
-	content, err := f.Executor.RunRead(context.TODO(), []string{
-		"cat",
-		path.Join(f.Pwd, name),
-	})
+// validPath reports whether the name is a valid path name like fs.ValidPath,
+// but names are not required to be valid UTF-8, since names of the files in
+// the container can contain any bytes.
+func validPath(name string) bool {
+	return fs.ValidPath(strings.ToValidUTF8(name, "_"))
+}
 
-	if err != nil {
-		return nil, err
-	}
-	file := PodFile{
-		name:    name,
-		fs:      f,
-		content: content,
+func (f *PodFS) capabilities() *Capabilities {
+	if f.Capabilities == nil {
+		return gnuCapabilities
 	}
-	return &file, nil
+	return f.Capabilities
 }
 
-func (f *PodFS) ReadDir(name string) ([]fs.DirEntry, error) {
-	p := path.Join(f.Pwd, name)
-	inf, err := f.Stat(name)
+// maxReadBlockSize is the maximum block size of dd to read a range of the
+// file.
+const maxReadBlockSize = 1 << 20
+
+func (f *PodFS) Open(name string) (fs.File, error) {
+	if !validPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	_, err := f.Stat(name)
 	if err != nil {
 		return nil, err
 	}
-	if !inf.IsDir() {
-		return nil, &fs.PathError{Op: "readdirent", Path: p, Err: syscall.ENOTDIR}
-	}
-
-	_, err = f.Executor.Run(context.TODO(), []string{
-		"ls", "/bin/busybox",
-	})
-	if err == nil {
-		return f.readDirSlow(name)
+	file := PodFile{
+		name: name,
+		fs:   f,
 	}
-	return f.readDir(name)
+	return &file, nil
 }
 
-func (f *PodFS) readDir(name string) ([]fs.DirEntry, error) {
-	p := path.Join(f.Pwd, name)
+// readRange reads at most size bytes from the offset of the file.
+func (f *PodFS) readRange(name string, off int64, size int) ([]byte, error) {
+	// Use the largest block size which aligns both the offset and the size
+	// to reduce the number of read(2) in the remote.
+	bs := int64(1)
+	for bs < maxReadBlockSize && off%(bs*2) == 0 && int64(size)%(bs*2) == 0 {
+		bs *= 2
+	}
 	output, err := f.Executor.Run(context.TODO(), []string{
-		"find", p, "-maxdepth", "1", "-mindepth", "1", "-printf", "%y:%f\n",
+		"dd",
+		"if=" + path.Join(f.Pwd, name),
+		"bs=" + strconv.FormatInt(bs, 10),
+		"skip=" + strconv.FormatInt(off/bs, 10),
+		"count=" + strconv.FormatInt(int64(size)/bs, 10),
 	})
 	if err != nil {
-		return nil, err
+		return nil, toOSError(err)
 	}
+	return output, nil
+}
 
-	var entries []fs.DirEntry
-	var mode fs.FileMode
-	s := bufio.NewScanner(bytes.NewReader(output))
-	for s.Scan() {
-		line := s.Text()
-		sp := strings.Split(line, ":")
-		modec, subname := sp[0], sp[1]
-		switch modec {
-		case "b":
-			mode |= fs.ModeDevice
-		case "c":
-			mode |= fs.ModeDevice | fs.ModeCharDevice
-		case "d":
-			mode |= fs.ModeDir
-		case "p":
-			mode |= fs.ModeNamedPipe
-		case "l":
-			mode |= fs.ModeSymlink
-		case "s":
-			mode |= fs.ModeSocket
-		}
-		e := &PodDirEntry{name: subname, mode: mode}
-		entries = append(entries, e)
-	}
-	if s.Err() != nil {
-		return nil, s.Err()
+// readDirScript prints the name and the attributes of the entries in the
+// directory $1 and the directory itself by the command in the rest of the
+// arguments for each entry.  It is used if stat does not support --printf,
+// such as busybox and toybox.
+const readDirScript = `dir=$1
+shift
+cd -- "$dir" || exit
+for f in . * .[!.]* ..?*; do
+	{ [ -e "$f" ] || [ -L "$f" ]; } || continue
+	s=$("$@" "$f") || continue
+	printf '%s\0%s\0' "$f" "$s"
+done`
+
+// readDirCommand returns a command to print the names and the attributes of
+// the entries in the directory and the directory itself.  The name and the
+// attributes of each entry are terminated by NUL, so names can contain any
+// bytes including tabs and newlines.
+func (f *PodFS) readDirCommand(dir string) ([]string, error) {
+	switch f.capabilities().readDirStrategy() {
+	case readDirFind:
+		return []string{
+			"find", dir, "-maxdepth", "1",
+			"-exec", "stat", "--printf", "%n\\0" + statFormat + "\\0", "--", "{}", "+",
+		}, nil
+	case readDirStat:
+		return []string{"sh", "-c", readDirScript, "sh", dir, "stat", "-c", statFormat, "--"}, nil
+	case readDirLs:
+		return []string{"sh", "-c", readDirScript, "sh", dir, "ls", "-dinl", "--"}, nil
 	}
-	return entries, nil
+	return nil, &fs.PathError{Op: "readdirent", Path: dir, Err: syscall.ENOTSUP}
+}
 
+// parseAttrs parses the attributes of the file printed by the stat or ls
+// command for the strategy.
+func parseAttrs(strategy, name, attrs string) (*PodFileInfo, error) {
+	if strategy == readDirLs || strategy == statLs {
+		return parseLs(name, attrs)
+	}
+	return parseStat(name, strings.Split(strings.TrimSuffix(attrs, "\n"), "\t"))
 }
 
-func (f *PodFS) readDirSlow(name string) ([]fs.DirEntry, error) {
-	p := path.Join(f.Pwd, name)
-	output, err := f.Executor.Run(context.TODO(), []string{
-		"ls",
-		"-A",
-		p,
-	})
+func (f *PodFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !validPath(name) {
+		return nil, &fs.PathError{Op: "readdirent", Path: name, Err: fs.ErrInvalid}
+	}
+	// Stat all entries and the directory itself by a single command.  The
+	// trailing "/." follows the directory even if it is a symlink.
+	p := strings.TrimSuffix(path.Join(f.Pwd, name), "/") + "/."
+	command, err := f.readDirCommand(p)
 	if err != nil {
 		return nil, err
 	}
-	files := strings.Split(string(output), "\n")
-	files = files[0 : len(files)-1]
-
-	subdir, err := f.Sub(name)
+	output, err := f.Executor.Run(context.TODO(), command)
 	if err != nil {
-		return nil, err
+		return nil, toOSError(err)
 	}
-	entries := make([]fs.DirEntry, len(files))
-	for i, file := range files {
-		inf, err := fs.Stat(subdir, file)
+
+	var entries []fs.DirEntry
+	var self *PodFileInfo
+	records := bytes.Split(output, []byte{0})
+	for i := 0; i+1 < len(records); i += 2 {
+		name, attrs := string(records[i]), string(records[i+1])
+		inf, err := parseAttrs(f.capabilities().readDirStrategy(), path.Base(name), attrs)
 		if err != nil {
-			return nil, toOSError(err)
+			return nil, err
 		}
-		entries[i] = &PodDirEntry{
-			name: file,
-			mode: inf.Mode(),
+		if inf.name == "." {
+			self = inf
+			continue
 		}
+		entries = append(entries, &PodDirEntry{
+			name: inf.name,
+			mode: inf.mode,
+			info: inf,
+		})
 	}
+	if self == nil {
+		return nil, &fs.PathError{Op: "readdirent", Path: p, Err: fs.ErrNotExist}
+	}
+	if !self.IsDir() {
+		return nil, &fs.PathError{Op: "readdirent", Path: p, Err: syscall.ENOTDIR}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
 	return entries, nil
 }
 
@@ -167,61 +206,155 @@ type LinuxStat_t struct {
 	Ctim    syscall.Timespec
 }
 
+// statFormat is a format of the stat command.  The output is parsed by
+// parseStat.  The format does not contain the name of the file, which is
+// printed separately to keep names with any bytes.  Nanoseconds of the
+// timestamps are taken from the human-readable %x, %y and %z, since busybox
+// and older coreutils do not support the precision of %X, %Y and %Z such as
+// %.9Y.
+var statFormat = strings.Join([]string{
+	"%i", "%s", "%B", "%b", "%f", "%X", "%Y", "%Z", "%u", "%g",
+	"%h", "%t", "%T", "%d", "%x", "%y", "%z",
+}, "\t")
+
+// statFields is the number of fields in statFormat.
+const statFields = 17
+
 func (f *PodFS) Stat(name string) (fs.FileInfo, error) {
-	output, err := f.Executor.Run(context.TODO(), []string{
-		"stat",
-		"-c",
-		strings.Join([]string{"%n", "%i", "%s", "%B", "%b", "%f", "%X", "%Y", "%Z", "%u", "%g"}, "\t"),
-		path.Join(f.Pwd, name),
-	})
+	p := path.Join(f.Pwd, name)
+	var command []string
+	strategy := f.capabilities().statStrategy()
+	switch strategy {
+	case statStat:
+		command = []string{"stat", "-c", statFormat, "--", p}
+	case statLs:
+		command = []string{"ls", "-dinl", "--", p}
+	default:
+		return nil, &fs.PathError{Op: "stat", Path: p, Err: syscall.ENOTSUP}
+	}
+	output, err := f.Executor.Run(context.TODO(), command)
 	if err != nil {
 		return nil, toOSError(err)
 	}
-	output = output[:len(output)-1] // trim a trailing new-line
-	parts := bytes.Split(output, []byte{'\t'})
-	if len(parts) != 11 {
-		return nil, fmt.Errorf("unexpected stat output: %s", output)
-	}
-	ino, err := strconv.ParseUint(string(parts[1]), 10, 64)
+	inf, err := parseAttrs(strategy, path.Base(p), string(output))
 	if err != nil {
 		return nil, err
 	}
-	size, err := strconv.ParseInt(string(parts[2]), 10, 64)
-	if err != nil {
-		return nil, err
+	return inf, nil
+}
+
+// parseStat parses fields of the stat command output in statFormat.
+func parseStat(name string, parts []string) (*PodFileInfo, error) {
+	if len(parts) != statFields {
+		return nil, fmt.Errorf("unexpected stat output: %q", strings.Join(parts, "\t"))
 	}
-	blksize, err := strconv.ParseInt(string(parts[3]), 10, 32)
-	if err != nil {
-		return nil, err
+
+	var err error
+	parseUint := func(s string, base int) uint64 {
+		n, e := strconv.ParseUint(s, base, 64)
+		if e != nil && err == nil {
+			err = e
+		}
+		return n
 	}
-	blocks, err := strconv.ParseInt(string(parts[4]), 10, 64)
-	if err != nil {
-		return nil, err
+	parseTime := func(sec, human string) syscall.Timespec {
+		n, e := strconv.ParseInt(sec, 10, 64)
+		if e != nil && err == nil {
+			err = e
+		}
+		return syscall.Timespec{Sec: n, Nsec: parseNsec(human)}
 	}
-	rawmode, err := strconv.ParseUint(string(parts[5]), 16, 32)
-	if err != nil {
-		return nil, err
+
+	rawmode := uint32(parseUint(parts[4], 16))
+	st := LinuxStat_t{
+		Ino:     parseUint(parts[0], 10),
+		Size:    int64(parseUint(parts[1], 10)),
+		Blksize: int64(parseUint(parts[2], 10)),
+		Blocks:  int64(parseUint(parts[3], 10)),
+		Mode:    rawmode,
+		Atim:    parseTime(parts[5], parts[14]),
+		Mtim:    parseTime(parts[6], parts[15]),
+		Ctim:    parseTime(parts[7], parts[16]),
+		Uid:     uint32(parseUint(parts[8], 10)),
+		Gid:     uint32(parseUint(parts[9], 10)),
+		Nlink:   parseUint(parts[10], 10),
+		Rdev:    mkdev(parseUint(parts[11], 16), parseUint(parts[12], 16)),
+		Dev:     parseUint(parts[13], 10),
 	}
-	atime, err := strconv.ParseInt(string(parts[6]), 10, 64)
 	if err != nil {
 		return nil, err
 	}
-	mtime, err := strconv.ParseInt(string(parts[7]), 10, 64)
-	if err != nil {
-		return nil, err
+	return &PodFileInfo{
+		name: name,
+		size: st.Size,
+		mode: toFileMode(rawmode),
+		sys:  st,
+	}, nil
+}
+
+// parseNsec returns nanoseconds in the fraction of seconds of the
+// human-readable time such as "2006-01-02 15:04:05.999999999 -0700".  It
+// returns zero if the time has no fraction.
+func parseNsec(human string) int64 {
+	i := strings.IndexByte(human, '.')
+	if i < 0 {
+		return 0
 	}
-	ctime, err := strconv.ParseInt(string(parts[8]), 10, 64)
-	if err != nil {
-		return nil, err
+	digits := human[i+1:]
+	if j := strings.IndexFunc(digits, func(r rune) bool { return r < '0' || r > '9' }); j >= 0 {
+		digits = digits[:j]
 	}
-	uid, err := strconv.ParseUint(string(parts[9]), 10, 32)
-	if err != nil {
-		return nil, err
+	if len(digits) > 9 {
+		digits = digits[:9]
 	}
-	gid, err := strconv.ParseUint(string(parts[10]), 10, 32)
+	nsec, err := strconv.ParseInt((digits + "000000000")[:9], 10, 64)
 	if err != nil {
-		return nil, err
+		return 0
+	}
+	return nsec
+}
+
+// mkdev returns a device number from the major and minor numbers in the
+// encoding of glibc on linux.
+func mkdev(major, minor uint64) uint64 {
+	return (major&0xfffff000)<<32 | (major&0xfff)<<8 |
+		(minor&0xffffff00)<<12 | minor&0xff
+}
+
+// toRawMode converts the permission and the setuid, setgid and sticky bits
+// of fs.FileMode to the bits of st_mode on linux, such as 04755.
+func toRawMode(mode fs.FileMode) uint32 {
+	rawmode := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		rawmode |= syscall.S_ISUID
+	}
+	if mode&fs.ModeSetgid != 0 {
+		rawmode |= syscall.S_ISGID
 	}
+	if mode&fs.ModeSticky != 0 {
+		rawmode |= syscall.S_ISVTX
+	}
+	return rawmode
+}
+
+// fromRawMode converts the permission and the setuid, setgid and sticky bits
+// of st_mode on linux to fs.FileMode.
+func fromRawMode(rawmode uint32) fs.FileMode {
+	mode := fs.FileMode(rawmode & 0777)
+	if rawmode&syscall.S_ISUID != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if rawmode&syscall.S_ISGID != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if rawmode&syscall.S_ISVTX != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
+
+// toFileMode converts st_mode on linux to fs.FileMode.
+func toFileMode(rawmode uint32) fs.FileMode {
 	mode := fs.FileMode(rawmode & 0777)
 	switch rawmode & S_IFMT {
 	case S_IFBLK:
@@ -239,47 +372,185 @@ func (f *PodFS) Stat(name string) (fs.FileInfo, error) {
 	case S_IFSOCK:
 		mode |= fs.ModeSocket
 	}
-	inf := &PodFileInfo{
-		name: path.Base(string(parts[0])),
-		mode: mode,
-		sys: LinuxStat_t{
-			Ino:     ino,
-			Mode:    uint32(rawmode),
-			Uid:     uint32(uid),
-			Gid:     uint32(gid),
-			Size:    size,
-			Blksize: blksize,
-			Blocks:  blocks,
-			Atim:    syscall.Timespec{Sec: atime},
-			Mtim:    syscall.Timespec{Sec: mtime},
-			Ctim:    syscall.Timespec{Sec: ctime},
-		},
-	}
-	return inf, nil
+	return mode
 }
 
 func (f *PodFS) Sub(dir string) (fs.FS, error) {
 	return &PodFS{
-		Executor: f.Executor,
-		Pwd:      path.Join(f.Pwd, dir),
+		Executor:     f.Executor,
+		Pwd:          path.Join(f.Pwd, dir),
+		Capabilities: f.Capabilities,
 	}, nil
 }
 
 func (f *PodFS) Readlink(name string) (string, error) {
-	output, err := f.Executor.Run(context.TODO(), []string{
-		"readlink",
+	p := path.Join(f.Pwd, name)
+	switch f.capabilities().readlinkStrategy() {
+	case readlinkReadlink:
+		output, err := f.Executor.Run(context.TODO(), []string{"readlink", "--", p})
+		if err != nil {
+			return "", toOSError(err)
+		}
+		// Only the trailing new-line added by readlink is trimmed, since the
+		// target can end with new-lines
+		return string(bytes.TrimSuffix(output, []byte{'\n'})), nil
+	case readlinkLs:
+		output, err := f.Executor.Run(context.TODO(), []string{"ls", "-dl", "--", p})
+		if err != nil {
+			return "", toOSError(err)
+		}
+		return parseLsLink(p, string(output))
+	}
+	return "", &fs.PathError{Op: "readlink", Path: p, Err: syscall.ENOTSUP}
+}
+
+// writeFileScript replaces the destination with the content from stdin.  The
+// content is written to a temporary file in the same directory first, and
+// then renamed to the destination, so that a partially written file never
+// appears in the pod.
+//
+// The mode and the owner of the existing file are copied to the temporary
+// file by stat -c, or by cp -p if $3 is "cp -p" for stat without -c.
+const writeFileScript = `set -e
+dst="$1"
+if [ -L "$dst" ]; then
+	dst="$(readlink -f "$dst")"
+fi
+tmp="$(dirname "$dst")/.$(basename "$dst").kubectl-mount.$$"
+trap 'rm -f "$tmp"' EXIT
+if [ -e "$dst" ] && [ "$3" = "cp -p" ]; then
+	cp -p "$dst" "$tmp"
+fi
+cat >"$tmp"
+if [ ! -e "$dst" ]; then
+	chmod "$2" "$tmp"
+elif [ "$3" != "cp -p" ]; then
+	chmod "$(stat -c %a "$dst")" "$tmp"
+	chown "$(stat -c %u:%g "$dst")" "$tmp" 2>/dev/null || true
+fi
+mv -f "$tmp" "$dst"
+`
+
+func (f *PodFS) WriteFile(name string, data io.Reader, perm fs.FileMode) error {
+	if !f.capabilities().Shell {
+		return &fs.PathError{Op: "write", Path: name, Err: syscall.ENOTSUP}
+	}
+	err := f.Executor.RunWrite(context.TODO(), []string{
+		"sh", "-c", writeFileScript, "sh",
 		path.Join(f.Pwd, name),
-	})
-	if err != nil {
-		return "", toOSError(err)
+		fmt.Sprintf("%o", perm.Perm()),
+		f.capabilities().copyModeStrategy(),
+	}, data)
+	return toOSError(err)
+}
+
+func (f *PodFS) Mkdir(name string, perm fs.FileMode) error {
+	return f.run("mkdir", "-m", fmt.Sprintf("%o", toRawMode(perm)), path.Join(f.Pwd, name))
+}
+
+func (f *PodFS) Remove(name string) error {
+	return f.run("rm", path.Join(f.Pwd, name))
+}
+
+func (f *PodFS) RemoveDir(name string) error {
+	return f.run("rmdir", path.Join(f.Pwd, name))
+}
+
+func (f *PodFS) Rename(oldname, newname string) error {
+	return f.run("mv", "-f", path.Join(f.Pwd, oldname), path.Join(f.Pwd, newname))
+}
+
+func (f *PodFS) Symlink(target, name string) error {
+	return f.run("ln", "-s", target, path.Join(f.Pwd, name))
+}
+
+func (f *PodFS) Link(oldname, newname string) error {
+	return f.run("ln", path.Join(f.Pwd, oldname), path.Join(f.Pwd, newname))
+}
+
+func (f *PodFS) Chmod(name string, mode fs.FileMode) error {
+	return f.run("chmod", fmt.Sprintf("%o", toRawMode(mode)), path.Join(f.Pwd, name))
+}
+
+func (f *PodFS) Chown(name string, uid, gid int) error {
+	owner := ""
+	if uid >= 0 {
+		owner = strconv.Itoa(uid)
+	}
+	if gid >= 0 {
+		owner += ":" + strconv.Itoa(gid)
+	}
+	return f.run("chown", "-h", owner, path.Join(f.Pwd, name))
+}
+
+func (f *PodFS) Truncate(name string, size int64) error {
+	p := path.Join(f.Pwd, name)
+	switch f.capabilities().truncateStrategy() {
+	case "":
+		return &fs.PathError{Op: "truncate", Path: name, Err: syscall.ENOTSUP}
+	case truncateDd:
+		// dd truncates or extends the output file at the seek offset
+		return f.run("dd", "if=/dev/null", "of="+p, "bs=1", "seek="+strconv.FormatInt(size, 10))
+	}
+	return f.run("truncate", "-s", strconv.FormatInt(size, 10), p)
+}
+
+// epochTime formats the time as seconds since the epoch with nanoseconds
+// for touch -d, such as @1617280496.000000789.
+func epochTime(t time.Time) string {
+	sec, nsec := t.Unix(), int64(t.Nanosecond())
+	sign := ""
+	if sec < 0 {
+		sign = "-"
+		if nsec > 0 {
+			sec++
+			nsec = 1e9 - nsec
+		}
+		sec = -sec
+	}
+	return fmt.Sprintf("@%s%d.%09d", sign, sec, nsec)
+}
+
+// touchScript runs touch in the UTC time zone, so touch -t accepts the time
+// in UTC.
+const touchScript = `TZ=UTC0 exec touch "$@"`
+
+func (f *PodFS) Chtimes(name string, atime, mtime time.Time) error {
+	p := path.Join(f.Pwd, name)
+	touch := func(flag string, t time.Time) error {
+		switch f.capabilities().touchStrategy() {
+		case touchTimestamp:
+			return f.run("sh", "-c", touchScript, "sh", "-c", flag, "-t", t.UTC().Format("200601021504.05"), p)
+		case "":
+			return &fs.PathError{Op: "chtimes", Path: p, Err: syscall.ENOTSUP}
+		}
+		return f.run("touch", "-c", flag, "-d", epochTime(t), p)
+	}
+	if !atime.IsZero() {
+		if err := touch("-a", atime); err != nil {
+			return err
+		}
 	}
-	return string(output[:len(output)-1]), nil
+	if !mtime.IsZero() {
+		return touch("-m", mtime)
+	}
+	return nil
+}
+
+func (f *PodFS) run(command ...string) error {
+	_, err := f.Executor.Run(context.TODO(), command)
+	return toOSError(err)
 }
 
 type PodFile struct {
 	name    string
-	fs      fs.StatFS
+	fs      *PodFS
 	content io.ReadCloser
+
+	// entries is the rest of the directory entries for ReadDir, which are
+	// read at the first call.
+	entries []fs.DirEntry
+	dirRead bool
 }
 
 func (f *PodFile) Stat() (fs.FileInfo, error) {
@@ -287,10 +558,64 @@ func (f *PodFile) Stat() (fs.FileInfo, error) {
 }
 
 func (f *PodFile) Read(b []byte) (int, error) {
-	return f.content.Read(b)
+	if f.content == nil {
+		content, err := f.fs.Executor.RunRead(context.TODO(), []string{
+			"cat",
+			path.Join(f.fs.Pwd, f.name),
+		})
+		if err != nil {
+			return 0, toOSError(err)
+		}
+		f.content = content
+	}
+	n, err := f.content.Read(b)
+	if err != nil && err != io.EOF {
+		return n, toOSError(err)
+	}
+	return n, err
+}
+
+func (f *PodFile) ReadAt(b []byte, off int64) (int, error) {
+	output, err := f.fs.readRange(f.name, off, len(b))
+	if err != nil {
+		return 0, err
+	}
+	n := copy(b, output)
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *PodFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !f.dirRead {
+		entries, err := f.fs.ReadDir(f.name)
+		if err != nil {
+			return nil, err
+		}
+		f.entries = entries
+		f.dirRead = true
+	}
+	if n <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(f.entries) {
+		n = len(f.entries)
+	}
+	entries := f.entries[:n]
+	f.entries = f.entries[n:]
+	return entries, nil
 }
 
 func (f *PodFile) Close() error {
+	if f.content == nil {
+		return nil
+	}
 	return f.content.Close()
 }
 
@@ -319,12 +644,51 @@ func Readlink(fsys fs.FS, name string) (string, error) {
 	panic("fsys does not implement a ReadlinkFS")
 }
 
+// WriteFS is the interface implemented by a file system which can modify
+// files on it.
+type WriteFS interface {
+	fs.FS
+
+	WriteFile(name string, data io.Reader, perm fs.FileMode) error
+	Mkdir(name string, perm fs.FileMode) error
+	Remove(name string) error
+	RemoveDir(name string) error
+	Rename(oldname, newname string) error
+	Symlink(target, name string) error
+	Link(oldname, newname string) error
+	Chmod(name string, mode fs.FileMode) error
+	Chown(name string, uid, gid int) error
+	Truncate(name string, size int64) error
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+var remoteErrors = []struct {
+	message string
+	errno   syscall.Errno
+}{
+	{"Permission denied", syscall.EACCES},
+	{"Operation not permitted", syscall.EPERM},
+	{"File exists", syscall.EEXIST},
+	{"Not a directory", syscall.ENOTDIR},
+	{"Is a directory", syscall.EISDIR},
+	{"Directory not empty", syscall.ENOTEMPTY},
+	{"Read-only file system", syscall.EROFS},
+	{"No space left on device", syscall.ENOSPC},
+	{"Invalid cross-device link", syscall.EXDEV},
+	{"Operation not supported", syscall.ENOTSUP},
+}
+
 func toOSError(err error) error {
 	var cmderr *RemoteCommandErr
 	if errors.As(err, &cmderr) {
 		if strings.Contains(string(cmderr.Stderr), "No such file or directory") {
 			return os.ErrNotExist
 		}
+		for _, e := range remoteErrors {
+			if strings.Contains(string(cmderr.Stderr), e.message) {
+				return e.errno
+			}
+		}
 	}
 	return err
 }
@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var testModTime = time.Date(2021, 4, 1, 12, 34, 56, 789000000, time.UTC)
+
+// testFiles returns files for tests, with names which need care in the
+// outputs of the commands.  fstest.MapFS cannot have names which are not
+// valid UTF-8, so they are tested only with local directories.
+func testFiles() fstest.MapFS {
+	return fstest.MapFS{
+		"hello.txt":           {Data: []byte("hello world\n"), Mode: 0644, ModTime: testModTime},
+		"empty":               {Mode: 0600, ModTime: testModTime},
+		"dir":                 {Mode: fs.ModeDir | 0755, ModTime: testModTime},
+		"dir/nested.txt":      {Data: []byte("nested"), Mode: 0644, ModTime: testModTime},
+		"dir/.hidden":         {Data: []byte("hidden"), Mode: 0400, ModTime: testModTime},
+		"dir/..dots":          {Data: []byte("dots"), Mode: 0644, ModTime: testModTime},
+		"dir/sub":             {Mode: fs.ModeDir | 0700, ModTime: testModTime},
+		"dir/sub/deep":        {Data: make([]byte, 5000), Mode: 0644, ModTime: testModTime},
+		"names":               {Mode: fs.ModeDir | 0755, ModTime: testModTime},
+		"names/with space":    {Data: []byte("1"), Mode: 0644, ModTime: testModTime},
+		"names/with\ttab":     {Data: []byte("2"), Mode: 0644, ModTime: testModTime},
+		"names/with\nnewline": {Data: []byte("3"), Mode: 0644, ModTime: testModTime},
+		"names/a:b -> c":      {Data: []byte("4"), Mode: 0644, ModTime: testModTime},
+	}
+}
+
+// writeLocalFiles writes files in the directory.
+func writeLocalFiles(t *testing.T, dir string, files fstest.MapFS) {
+	t.Helper()
+	for name, f := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		var err error
+		switch {
+		case f.Mode.IsDir():
+			err = os.MkdirAll(p, 0755)
+		case f.Mode&fs.ModeSymlink != 0:
+			err = os.Symlink(string(f.Data), p)
+		default:
+			if err = os.MkdirAll(filepath.Dir(p), 0755); err == nil {
+				err = os.WriteFile(p, f.Data, 0644)
+			}
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Modes and times are set after all files are created, since creating
+	// files changes modes and times of the directories
+	for name, f := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if f.Mode&fs.ModeSymlink != 0 {
+			continue
+		}
+		if err := os.Chmod(p, f.Mode.Perm()); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, f.ModTime, f.ModTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testFlavors is the capabilities of the userlands to test PodFS with, which
+// select each strategy of the commands.
+var testFlavors = []struct {
+	name string
+	caps *Capabilities
+}{
+	{"gnu", gnuCapabilities},
+	{"busybox", &Capabilities{Userland: userlandBusybox, Shell: true, StatFormat: true, Commands: map[string]bool{"ls": true, "df": true}}},
+	{"toybox", &Capabilities{Userland: userlandToybox, Shell: true, Commands: map[string]bool{"ls": true, "df": true, "readlink": true}}},
+}
+
+func TestPodFS(t *testing.T) {
+	expected := []string{"hello.txt", "dir/sub/deep", "dir/.hidden", "names/with\nnewline"}
+
+	for _, flavor := range testFlavors {
+		t.Run(flavor.name, func(t *testing.T) {
+			t.Run("MapFS", func(t *testing.T) {
+				f := &PodFS{Executor: newFakeExecutor(testFiles()), Pwd: "/", Capabilities: flavor.caps}
+				if err := fstest.TestFS(f, expected...); err != nil {
+					t.Fatal(err)
+				}
+			})
+			t.Run("local", func(t *testing.T) {
+				dir := t.TempDir()
+				files := testFiles()
+				files["names/\xff\xfe"] = &fstest.MapFile{Data: []byte("5"), Mode: 0644, ModTime: testModTime}
+				writeLocalFiles(t, dir, files)
+				f := &PodFS{Executor: newFakeExecutor(localDirFS(dir)), Pwd: "/", Capabilities: flavor.caps}
+				if err := fstest.TestFS(f, append(expected, "names/\xff\xfe")...); err != nil {
+					t.Fatal(err)
+				}
+			})
+			t.Run("Sub", func(t *testing.T) {
+				f := &PodFS{Executor: newFakeExecutor(testFiles()), Pwd: "/dir", Capabilities: flavor.caps}
+				if err := fstest.TestFS(f, "nested.txt", "sub/deep"); err != nil {
+					t.Fatal(err)
+				}
+			})
+		})
+	}
+}
+
+func TestPodFSStat(t *testing.T) {
+	files := fstest.MapFS{
+		"file": {Data: []byte("data"), Mode: 0640, ModTime: testModTime,
+			Sys: &LinuxStat_t{Ino: 42, Uid: 1000, Gid: 2000, Nlink: 3}},
+		"setuid": {Mode: fs.ModeSetuid | fs.ModeSetgid | 0755, ModTime: testModTime},
+		"tmp":    {Mode: fs.ModeDir | fs.ModeSticky | 0777, ModTime: testModTime},
+		"null": {Mode: fs.ModeDevice | fs.ModeCharDevice | 0666, ModTime: testModTime,
+			Sys: &LinuxStat_t{Rdev: mkdev(1, 3)}},
+		"sda": {Mode: fs.ModeDevice | 0660, ModTime: testModTime,
+			Sys: &LinuxStat_t{Rdev: mkdev(259, 65536)}},
+		"fifo": {Mode: fs.ModeNamedPipe | 0644, ModTime: testModTime},
+		"sock": {Mode: fs.ModeSocket | 0755, ModTime: testModTime},
+		"link": {Data: []byte("file"), Mode: fs.ModeSymlink | 0777, ModTime: testModTime},
+	}
+	tests := []struct {
+		name    string
+		mode    fs.FileMode
+		rawmode uint32
+		rdev    uint64
+	}{
+		{"file", 0640, S_IFREG | 0640, 0},
+		{"setuid", 0755, S_IFREG | syscall.S_ISUID | syscall.S_ISGID | 0755, 0},
+		{"tmp", fs.ModeDir | 0777, S_IFDIR | syscall.S_ISVTX | 0777, 0},
+		{"null", fs.ModeDevice | fs.ModeCharDevice | 0666, S_IFCHR | 0666, mkdev(1, 3)},
+		{"sda", fs.ModeDevice | 0660, S_IFBLK | 0660, mkdev(259, 65536)},
+		{"fifo", fs.ModeNamedPipe | 0644, S_IFIFO | 0644, 0},
+		{"sock", fs.ModeSocket | 0755, S_IFSOCK | 0755, 0},
+		{"link", fs.ModeSymlink | 0777, S_IFLNK | 0777, 0},
+	}
+	for _, flavor := range testFlavors {
+		f := &PodFS{Executor: newFakeExecutor(files), Pwd: "/", Capabilities: flavor.caps}
+		entries, err := f.ReadDir(".")
+		if err != nil {
+			t.Fatalf("%s: %v", flavor.name, err)
+		}
+		infos := map[string]fs.FileInfo{}
+		for _, e := range entries {
+			infos[e.Name()], _ = e.Info()
+		}
+
+		for _, tt := range tests {
+			t.Run(flavor.name+"/"+tt.name, func(t *testing.T) {
+				inf, err := f.Stat(tt.name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, inf := range []fs.FileInfo{inf, infos[tt.name]} {
+					st := inf.Sys().(*LinuxStat_t)
+					if inf.Mode() != tt.mode {
+						t.Errorf("mode = %v, want %v", inf.Mode(), tt.mode)
+					}
+					if st.Mode != tt.rawmode {
+						t.Errorf("st_mode = %o, want %o", st.Mode, tt.rawmode)
+					}
+					if st.Rdev != tt.rdev {
+						t.Errorf("rdev = %x, want %x", st.Rdev, tt.rdev)
+					}
+				}
+			})
+		}
+	}
+
+	for _, flavor := range testFlavors {
+		t.Run(flavor.name+"/attributes", func(t *testing.T) {
+			f := &PodFS{Executor: newFakeExecutor(files), Pwd: "/", Capabilities: flavor.caps}
+			inf, err := f.Stat("file")
+			if err != nil {
+				t.Fatal(err)
+			}
+			st := inf.Sys().(*LinuxStat_t)
+			if inf.Size() != 4 || st.Ino != 42 || st.Uid != 1000 || st.Gid != 2000 || st.Nlink != 3 {
+				t.Errorf("unexpected stat: %+v", st)
+			}
+			// ls shows only the date of old files
+			want := testModTime
+			if flavor.caps.statStrategy() == statLs {
+				want = want.Truncate(24 * time.Hour)
+			}
+			if !inf.ModTime().Equal(want) {
+				t.Errorf("mtime = %v, want %v", inf.ModTime(), want)
+			}
+
+			target, err := f.Readlink("link")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if target != "file" {
+				t.Errorf("target = %q, want %q", target, "file")
+			}
+		})
+	}
+}
+
+func TestPodFSErrors(t *testing.T) {
+	for _, flavor := range testFlavors {
+		t.Run(flavor.name, func(t *testing.T) {
+			f := &PodFS{Executor: newFakeExecutor(testFiles()), Pwd: "/", Capabilities: flavor.caps}
+			if _, err := f.Stat("missing"); !os.IsNotExist(err) {
+				t.Errorf("Stat: err = %v, want not exist", err)
+			}
+			if _, err := f.ReadDir("missing"); !os.IsNotExist(err) {
+				t.Errorf("ReadDir: err = %v, want not exist", err)
+			}
+			if _, err := f.ReadDir("hello.txt"); err == nil {
+				t.Errorf("ReadDir of a file succeeded")
+			}
+			if _, err := f.Open("../hello.txt"); err == nil {
+				t.Errorf("Open of an invalid path succeeded")
+			}
+			if err := f.Mkdir("new", 0755); err != syscall.EROFS {
+				t.Errorf("Mkdir: err = %v, want EROFS", err)
+			}
+		})
+	}
+}
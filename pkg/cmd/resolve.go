@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// selectorForObject returns a label selector of pods controlled by the
+// workload.
+func selectorForObject(obj runtime.Object) (labels.Selector, error) {
+	var selector *metav1.LabelSelector
+	switch t := obj.(type) {
+	case *appsv1.Deployment:
+		selector = t.Spec.Selector
+	case *appsv1.StatefulSet:
+		selector = t.Spec.Selector
+	case *appsv1.DaemonSet:
+		selector = t.Spec.Selector
+	case *appsv1.ReplicaSet:
+		selector = t.Spec.Selector
+	case *batchv1.Job:
+		selector = t.Spec.Selector
+	case *corev1.ReplicationController:
+		return labels.SelectorFromSet(t.Spec.Selector), nil
+	case *corev1.Service:
+		if len(t.Spec.Selector) == 0 {
+			return nil, fmt.Errorf("service %s has no selector", t.Name)
+		}
+		return labels.SelectorFromSet(t.Spec.Selector), nil
+	default:
+		return nil, fmt.Errorf("cannot select pods from %T", obj)
+	}
+	if selector == nil {
+		return nil, fmt.Errorf("%T has no selector", obj)
+	}
+	return metav1.LabelSelectorAsSelector(selector)
+}
+
+// resolveSelector returns a label selector of pods specified by the label
+// selector or the workload reference such as deploy/nginx.  It returns the pod
+// instead if the reference is a pod.
+func (o *MountOptions) resolveSelector() (labels.Selector, *corev1.Pod, error) {
+	if o.Selector != "" {
+		s, err := labels.Parse(o.Selector)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, nil, nil
+	}
+
+	obj, err := resource.NewBuilder(o.configFlags).
+		WithScheme(scheme.Scheme, scheme.Scheme.PrioritizedVersionsAllGroups()...).
+		NamespaceParam(o.Namespace).DefaultNamespace().
+		ResourceNames("pods", o.PodName).
+		SingleResourceType().
+		Do().Object()
+	if err != nil {
+		return nil, nil, err
+	}
+	if pod, ok := obj.(*corev1.Pod); ok {
+		return nil, pod, nil
+	}
+	s, err := selectorForObject(obj)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, nil, nil
+}
+
+// resolvePod returns a pod specified by the pod name, the workload reference
+// such as deploy/nginx, or the label selector.  It waits until a running pod
+// is found for the workload reference and the label selector.
+func (o *MountOptions) resolvePod(ctx context.Context, api kubernetes.Interface) (*corev1.Pod, error) {
+	if o.Selector == "" && !strings.Contains(o.PodName, "/") {
+		return api.CoreV1().Pods(o.Namespace).Get(ctx, o.PodName, metav1.GetOptions{})
+	}
+	selector, pod, err := o.resolveSelector()
+	if err != nil {
+		return nil, err
+	}
+	if pod != nil {
+		return pod, nil
+	}
+
+	err = wait.PollImmediate(time.Second, o.PodRunningTimeout, func() (bool, error) {
+		pods, err := api.CoreV1().Pods(o.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			return false, err
+		}
+		pod = selectPod(pods.Items)
+		return pod != nil, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return nil, fmt.Errorf("timed out waiting for a running pod matching %s", selector)
+	} else if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "Using pod %s\n", pod.Name)
+	return pod, nil
+}
+
+// selectPod returns the best pod to mount from the pods, or nil if no
+// running pods.  Ready pods are preferred, and newer pods are preferred next.
+func selectPod(pods []corev1.Pod) *corev1.Pod {
+	var candidates []*corev1.Pod
+	for i := range pods {
+		p := &pods[i]
+		if p.DeletionTimestamp == nil && p.Status.Phase == corev1.PodRunning {
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ri, rj := isPodReady(candidates[i]), isPodReady(candidates[j])
+		if ri != rj {
+			return ri
+		}
+		return candidates[j].CreationTimestamp.Before(&candidates[i].CreationTimestamp)
+	})
+	return candidates[0]
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// runningContainers returns names of the running containers in the pod,
+// including init containers and ephemeral containers.  Ephemeral containers
+// created by kubectl-mount are excluded.
+func runningContainers(pod *corev1.Pod) []string {
+	var names []string
+	for _, statuses := range [][]corev1.ContainerStatus{
+		pod.Status.InitContainerStatuses,
+		pod.Status.ContainerStatuses,
+		pod.Status.EphemeralContainerStatuses,
+	} {
+		for _, s := range statuses {
+			if s.State.Running != nil && !strings.HasPrefix(s.Name, ephemeralContainerPrefix) {
+				names = append(names, s.Name)
+			}
+		}
+	}
+	return names
+}
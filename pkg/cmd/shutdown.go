@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Exit codes of the mount command when the filesystem is busy on shutdown
+const (
+	// ExitCodeBusy means that the command gave up unmounting the busy
+	// filesystem, and the mountpoint is left mounted.
+	ExitCodeBusy = 2
+
+	// ExitCodeLazyUnmount means that the busy filesystem was detached
+	// lazily by --lazy-unmount.
+	ExitCodeLazyUnmount = 3
+)
+
+// maxUnmountAttempts is the number of signals to retry unmounting the busy
+// filesystem before giving up.
+const maxUnmountAttempts = 3
+
+// ExitError is an error with the exit code of the command.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// mountHolder is a local process using files in the mountpoint.
+type mountHolder struct {
+	PID     int
+	Command string
+	Path    string
+}
+
+// unmountOnSignal unmounts the filesystem when a signal is received.  If the
+// filesystem is busy, it reports processes using the filesystem, and retries
+// on the following signals.  It returns an ExitError when it detaches the
+// filesystem lazily or gives up unmounting.
+func (o *MountOptions) unmountOnSignal(srv *fuse.Server, signals <-chan os.Signal) error {
+	for attempt := 1; ; attempt++ {
+		<-signals
+		err := srv.Unmount()
+		if err == nil {
+			return nil
+		}
+		fmt.Fprintln(os.Stderr, "Unable to unmount:", strings.TrimSpace(err.Error()))
+		if holders := findMountHolders(o.MountPoint); len(holders) > 0 {
+			fmt.Fprintf(os.Stderr, "%s is used by the following processes:\n", o.MountPoint)
+			for _, h := range holders {
+				fmt.Fprintf(os.Stderr, "  %d\t%s\t%s\n", h.PID, h.Command, h.Path)
+			}
+		}
+
+		if o.LazyUnmount {
+			if err := unmount(o.MountPoint, true); err != nil {
+				fmt.Fprintln(os.Stderr, "Unable to unmount lazily:", err)
+			} else {
+				fmt.Fprintln(os.Stderr, "Unmounted lazily; waiting for the processes to close the files")
+				return &ExitError{
+					Code: ExitCodeLazyUnmount,
+					Err:  fmt.Errorf("%s was busy and unmounted lazily", o.MountPoint),
+				}
+			}
+		}
+		if attempt >= maxUnmountAttempts {
+			return &ExitError{
+				Code: ExitCodeBusy,
+				Err:  fmt.Errorf("gave up unmounting %s after %d attempts. Unmount it by 'kubectl mount umount --lazy %s'", o.MountPoint, attempt, o.MountPoint),
+			}
+		}
+		fmt.Fprintf(os.Stderr, "Close the files and interrupt again to retry (%d/%d), or use --lazy-unmount\n", attempt, maxUnmountAttempts)
+	}
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// MountState is a record of an active mount.  It is saved in the state
+// directory while the mount process is running.
+type MountState struct {
+	PID        int       `json:"pid"`
+	Namespace  string    `json:"namespace"`
+	Pod        string    `json:"pod"`
+	Container  string    `json:"container,omitempty"`
+	RemoteDir  string    `json:"remoteDir"`
+	MountPoint string    `json:"mountPoint"`
+	Background bool      `json:"background,omitempty"`
+	Started    time.Time `json:"started"`
+}
+
+// stateDir returns the directory to save records of active mounts.  It is
+// $XDG_STATE_HOME/kubectl-mount, or ~/.local/state/kubectl-mount.
+func stateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "kubectl-mount"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "kubectl-mount"), nil
+}
+
+// statePath returns the path of the file in the state directory named
+// after the PID of the mount process.
+func statePath(pid int, ext string) (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, strconv.Itoa(pid)+ext), nil
+}
+
+// saveMountState records the active mount in the state directory.
+func saveMountState(s *MountState) error {
+	p, err := statePath(s.PID, ".json")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it, so that the list never
+	// reads a half-written record.
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// removeMountState removes the record and the log of the mount process.
+func removeMountState(pid int) {
+	for _, ext := range []string{".json", ".log"} {
+		if p, err := statePath(pid, ext); err == nil {
+			os.Remove(p)
+		}
+	}
+}
+
+// loadMountStates returns records of the active mounts ordered by the
+// mountpoint.  Records of exited processes are removed.
+func loadMountStates() ([]*MountState, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var states []*MountState
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var s MountState
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, fmt.Errorf("invalid state %s: %w", f.Name(), err)
+		}
+		if !processAlive(s.PID) {
+			removeMountState(s.PID)
+			continue
+		}
+		states = append(states, &s)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].MountPoint < states[j].MountPoint
+	})
+	return states, nil
+}
+
+// processAlive returns true if the process of the PID exists.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"syscall"
+
+	fusefs "github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// LinuxStatfs_t is statistics of a filesystem on the container, like
+// struct statfs on linux.  Numbers of blocks are in units of Frsize.
+type LinuxStatfs_t struct {
+	Bsize   int64
+	Frsize  int64
+	Blocks  uint64
+	Bfree   uint64
+	Bavail  uint64
+	Files   uint64
+	Ffree   uint64
+	Namelen int64
+}
+
+// StatfsFS is the interface implemented by a file system which can report
+// statistics of the filesystems on it.
+type StatfsFS interface {
+	fs.FS
+
+	// Statfs returns statistics of the filesystem containing the file.
+	Statfs(name string) (*LinuxStatfs_t, error)
+}
+
+// Statfs returns statistics of the filesystem containing the file on the
+// file system, or syscall.ENOTSUP if fsys does not implement StatfsFS.
+func Statfs(fsys fs.FS, name string) (*LinuxStatfs_t, error) {
+	if fsys, ok := fsys.(StatfsFS); ok {
+		return fsys.Statfs(name)
+	}
+	return nil, syscall.ENOTSUP
+}
+
+// statfsFormat is a format of the stat -f command.  The output is parsed by
+// parseStatfs.
+const statfsFormat = "%S %s %b %f %a %c %d %l"
+
+func (f *PodFS) Statfs(name string) (*LinuxStatfs_t, error) {
+	p := path.Join(f.Pwd, name)
+	switch f.capabilities().statfsStrategy() {
+	case statfsStat:
+		output, err := f.Executor.Run(context.TODO(), []string{"stat", "-f", "-c", statfsFormat, "--", p})
+		if err != nil {
+			return nil, toOSError(err)
+		}
+		return parseStatfs(output)
+	case statfsDf:
+		output, err := f.Executor.Run(context.TODO(), []string{"df", "-P", "-k", "--", p})
+		if err != nil {
+			return nil, toOSError(err)
+		}
+		return parseDf(output)
+	}
+	return nil, syscall.ENOTSUP
+}
+
+// parseStatfs parses a line of the stat -f command output in statfsFormat.
+func parseStatfs(output []byte) (*LinuxStatfs_t, error) {
+	parts := bytes.Fields(output)
+	if len(parts) != 8 {
+		return nil, fmt.Errorf("unexpected stat -f output: %s", output)
+	}
+	var nums [8]uint64
+	for i, p := range parts {
+		n, err := strconv.ParseUint(string(p), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		nums[i] = n
+	}
+	return &LinuxStatfs_t{
+		Frsize:  int64(nums[0]),
+		Bsize:   int64(nums[1]),
+		Blocks:  nums[2],
+		Bfree:   nums[3],
+		Bavail:  nums[4],
+		Files:   nums[5],
+		Ffree:   nums[6],
+		Namelen: int64(nums[7]),
+	}, nil
+}
+
+// Statfs reports the capacity of the filesystem on the container, so df on
+// the mountpoint shows the usage of the remote volume.  Zeros are reported
+// if the backend cannot get the statistics.
+func (n *PodFuseNode) Statfs(ctx context.Context, out *fuse.StatfsOut) syscall.Errno {
+	st, err := Statfs(n.fsys, n.file)
+	if errors.Is(err, syscall.ENOTSUP) {
+		return fusefs.OK
+	}
+	if err != nil {
+		return fusefs.ToErrno(err)
+	}
+	out.Bsize = uint32(st.Bsize)
+	out.Frsize = uint32(st.Frsize)
+	out.Blocks = st.Blocks
+	out.Bfree = st.Bfree
+	out.Bavail = st.Bavail
+	out.Files = st.Files
+	out.Ffree = st.Ffree
+	out.NameLen = uint32(st.Namelen)
+	return fusefs.OK
+}
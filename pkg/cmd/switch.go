@@ -0,0 +1,294 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// generationFS is implemented by filesystems which can be switched to
+// another container.  The generation changes on each switch.
+type generationFS interface {
+	Generation() uint64
+}
+
+// generation returns the generation of the filesystem, or zero if the
+// filesystem is never switched.
+func generation(fsys fs.FS) uint64 {
+	if g, ok := fsys.(generationFS); ok {
+		return g.Generation()
+	}
+	return 0
+}
+
+// SwitchFS is a filesystem delegating operations to the filesystem on the
+// current container.  The container is switched when the pod is replaced.
+// Files opened before the switch fail with ESTALE.
+type SwitchFS struct {
+	mu       sync.RWMutex
+	fsys     fs.FS
+	release  func()
+	gen      uint64
+	switched chan struct{}
+}
+
+// Switch replaces the current filesystem with fsys, and releases the old
+// one.  A nil fsys makes all operations fail with EIO until the next switch.
+func (f *SwitchFS) Switch(fsys fs.FS, release func()) {
+	f.mu.Lock()
+	old := f.release
+	f.fsys = fsys
+	f.release = release
+	f.gen++
+	if f.switched != nil {
+		close(f.switched)
+	}
+	f.switched = make(chan struct{})
+	f.mu.Unlock()
+
+	if old != nil {
+		old()
+	}
+}
+
+// Close releases the current filesystem.
+func (f *SwitchFS) Close() {
+	f.Switch(nil, nil)
+}
+
+func (f *SwitchFS) Generation() uint64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.gen
+}
+
+func (f *SwitchFS) current(op, name string) (fs.FS, uint64, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.fsys == nil {
+		return nil, 0, &fs.PathError{Op: op, Path: name, Err: syscall.EIO}
+	}
+	return f.fsys, f.gen, nil
+}
+
+func (f *SwitchFS) writableFS(op, name string) (WriteFS, error) {
+	fsys, _, err := f.current(op, name)
+	if err != nil {
+		return nil, err
+	}
+	wfs, ok := fsys.(WriteFS)
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: syscall.EROFS}
+	}
+	return wfs, nil
+}
+
+func (f *SwitchFS) Open(name string) (fs.File, error) {
+	fsys, gen, err := f.current("open", name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &switchFile{File: file, fs: f, gen: gen}, nil
+}
+
+// Watch watches changes of files on the current filesystem, and restarts
+// watching on the new filesystem when it is switched.
+func (f *SwitchFS) Watch(ctx context.Context, changed func(name string)) error {
+	for {
+		f.mu.RLock()
+		fsys, switched := f.fsys, f.switched
+		f.mu.RUnlock()
+
+		wfs, ok := fsys.(WatchFS)
+		if !ok {
+			select {
+			case <-switched:
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		wctx, cancel := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-switched:
+			case <-wctx.Done():
+			}
+			cancel()
+		}()
+		err := wfs.Watch(wctx, changed)
+		cancel()
+		select {
+		case <-switched:
+			// The whole tree is changed by the switch
+			changed(".")
+		default:
+			return err
+		}
+	}
+}
+
+func (f *SwitchFS) Stat(name string) (fs.FileInfo, error) {
+	fsys, _, err := f.current("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(fsys, name)
+}
+
+func (f *SwitchFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	fsys, _, err := f.current("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadDir(fsys, name)
+}
+
+func (f *SwitchFS) Readlink(name string) (string, error) {
+	fsys, _, err := f.current("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	return Readlink(fsys, name)
+}
+
+func (f *SwitchFS) Xattrs(name string) (map[string][]byte, error) {
+	fsys, _, err := f.current("xattrs", name)
+	if err != nil {
+		return nil, err
+	}
+	return Xattrs(fsys, name)
+}
+
+func (f *SwitchFS) Statfs(name string) (*LinuxStatfs_t, error) {
+	fsys, _, err := f.current("statfs", name)
+	if err != nil {
+		return nil, err
+	}
+	return Statfs(fsys, name)
+}
+
+func (f *SwitchFS) WriteFile(name string, data io.Reader, perm fs.FileMode) error {
+	wfs, err := f.writableFS("write", name)
+	if err != nil {
+		return err
+	}
+	return wfs.WriteFile(name, data, perm)
+}
+
+func (f *SwitchFS) Mkdir(name string, perm fs.FileMode) error {
+	wfs, err := f.writableFS("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return wfs.Mkdir(name, perm)
+}
+
+func (f *SwitchFS) Remove(name string) error {
+	wfs, err := f.writableFS("remove", name)
+	if err != nil {
+		return err
+	}
+	return wfs.Remove(name)
+}
+
+func (f *SwitchFS) RemoveDir(name string) error {
+	wfs, err := f.writableFS("rmdir", name)
+	if err != nil {
+		return err
+	}
+	return wfs.RemoveDir(name)
+}
+
+func (f *SwitchFS) Rename(oldname, newname string) error {
+	wfs, err := f.writableFS("rename", oldname)
+	if err != nil {
+		return err
+	}
+	return wfs.Rename(oldname, newname)
+}
+
+func (f *SwitchFS) Symlink(target, name string) error {
+	wfs, err := f.writableFS("symlink", name)
+	if err != nil {
+		return err
+	}
+	return wfs.Symlink(target, name)
+}
+
+func (f *SwitchFS) Link(oldname, newname string) error {
+	wfs, err := f.writableFS("link", oldname)
+	if err != nil {
+		return err
+	}
+	return wfs.Link(oldname, newname)
+}
+
+func (f *SwitchFS) Chmod(name string, mode fs.FileMode) error {
+	wfs, err := f.writableFS("chmod", name)
+	if err != nil {
+		return err
+	}
+	return wfs.Chmod(name, mode)
+}
+
+func (f *SwitchFS) Chown(name string, uid, gid int) error {
+	wfs, err := f.writableFS("chown", name)
+	if err != nil {
+		return err
+	}
+	return wfs.Chown(name, uid, gid)
+}
+
+func (f *SwitchFS) Truncate(name string, size int64) error {
+	wfs, err := f.writableFS("truncate", name)
+	if err != nil {
+		return err
+	}
+	return wfs.Truncate(name, size)
+}
+
+func (f *SwitchFS) Chtimes(name string, atime, mtime time.Time) error {
+	wfs, err := f.writableFS("chtimes", name)
+	if err != nil {
+		return err
+	}
+	return wfs.Chtimes(name, atime, mtime)
+}
+
+// switchFile is a file opened by SwitchFS.  It fails with ESTALE after the
+// filesystem is switched.
+type switchFile struct {
+	fs.File
+	fs  *SwitchFS
+	gen uint64
+}
+
+func (f *switchFile) stale() bool {
+	return f.fs.Generation() != f.gen
+}
+
+func (f *switchFile) Read(b []byte) (int, error) {
+	if f.stale() {
+		return 0, syscall.ESTALE
+	}
+	return f.File.Read(b)
+}
+
+func (f *switchFile) ReadAt(b []byte, off int64) (int, error) {
+	if f.stale() {
+		return 0, syscall.ESTALE
+	}
+	r, ok := f.File.(io.ReaderAt)
+	if !ok {
+		return 0, syscall.EBADF
+	}
+	return r.ReadAt(b, off)
+}
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+const (
+	umountUsageStr = "umount (mountpoint... | --all)"
+
+	umountExample = `
+	# Unmount the filesystem mounted on /tmp/nginx
+	kubectl mount umount /tmp/nginx
+
+	# Unmount all filesystems mounted by kubectl mount
+	kubectl mount umount --all
+
+	# Unmount the filesystem even if it is busy
+	kubectl mount umount --lazy /tmp/nginx`
+)
+
+// umountExitTimeout is a duration to wait for the mount process to exit
+// after the filesystem is unmounted.
+const umountExitTimeout = 10 * time.Second
+
+type UmountOptions struct {
+	MountPoints []string
+	All         bool
+	Lazy        bool
+
+	genericclioptions.IOStreams
+}
+
+func NewUmountOptions(streams genericclioptions.IOStreams) *UmountOptions {
+	return &UmountOptions{
+		IOStreams: streams,
+	}
+}
+
+// NewCmdUmount provides a cobra command wrapping UmountOptions
+func NewCmdUmount(streams genericclioptions.IOStreams) *cobra.Command {
+	o := NewUmountOptions(streams)
+
+	cmd := &cobra.Command{
+		Use:          umountUsageStr,
+		Aliases:      []string{"unmount"},
+		Short:        "Unmount filesystems mounted by kubectl mount",
+		Example:      umountExample,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(args); err != nil {
+				return err
+			}
+			return o.RunUmount()
+		},
+	}
+
+	cmd.Flags().BoolVar(&o.All, "all", false, "Unmount all filesystems mounted by kubectl mount")
+	cmd.Flags().BoolVar(&o.Lazy, "lazy", false, "Detach the filesystem lazily if it is busy. The filesystem is cleaned up when it is no longer used")
+
+	return cmd
+}
+
+func (o *UmountOptions) Complete(args []string) error {
+	if o.All && len(args) > 0 {
+		return errors.New("mountpoints and --all cannot be specified at the same time")
+	}
+	if !o.All && len(args) == 0 {
+		return fmt.Errorf("expected '%s'. The mountpoint is required", umountUsageStr)
+	}
+	for _, arg := range args {
+		mp, err := filepath.Abs(arg)
+		if err != nil {
+			return err
+		}
+		o.MountPoints = append(o.MountPoints, mp)
+	}
+	return nil
+}
+
+// RunUmount unmounts the filesystems, and waits for the mount processes to
+// exit.
+func (o *UmountOptions) RunUmount() error {
+	states, err := loadMountStates()
+	if err != nil {
+		return err
+	}
+	pids := map[string]int{}
+	for _, s := range states {
+		pids[s.MountPoint] = s.PID
+	}
+
+	mountpoints := o.MountPoints
+	if o.All {
+		if len(states) == 0 {
+			fmt.Fprintln(o.ErrOut, "No mounts found.")
+			return nil
+		}
+		for _, s := range states {
+			mountpoints = append(mountpoints, s.MountPoint)
+		}
+	}
+
+	// Mountpoints not recorded are also unmounted, such as the
+	// mountpoint left by the crashed process.
+	var errs []error
+	for _, mp := range mountpoints {
+		if err := o.umount(mp, pids[mp]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func (o *UmountOptions) umount(mountpoint string, pid int) error {
+	err := unmount(mountpoint, false)
+	if err != nil && !o.Lazy {
+		return fmt.Errorf("unable to unmount %s: %w. Close the files in the mountpoint, or retry with --lazy", mountpoint, err)
+	}
+	if err != nil {
+		if err := unmount(mountpoint, true); err != nil {
+			return fmt.Errorf("unable to unmount %s: %w", mountpoint, err)
+		}
+		fmt.Fprintf(o.ErrOut, "Lazily unmounted %s. The process exits when the filesystem is no longer used\n", mountpoint)
+		return nil
+	}
+
+	if pid != 0 {
+		err := wait.PollImmediate(100*time.Millisecond, umountExitTimeout, func() (bool, error) {
+			return !processAlive(pid), nil
+		})
+		if err != nil {
+			fmt.Fprintf(o.ErrOut, "Unmounted %s, but the process %d is still running\n", mountpoint, pid)
+			return nil
+		}
+	}
+	fmt.Fprintf(o.ErrOut, "Unmounted %s\n", mountpoint)
+	return nil
+}
@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// unmount unmounts the FUSE filesystem on the mountpoint by fusermount.  The
+// lazy unmount detaches the filesystem even if it is busy, and the
+// filesystem is cleaned up when it is no longer used.
+func unmount(mountpoint string, lazy bool) error {
+	args := []string{"-u"}
+	if lazy {
+		args = append(args, "-z")
+	}
+	output, err := exec.Command("fusermount", append(args, mountpoint)...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return fmt.Errorf("%s", msg)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+// +build !linux
+
+package cmd
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// unmount unmounts the FUSE filesystem on the mountpoint by umount.  The
+// lazy unmount forces to unmount the filesystem even if it is busy.
+func unmount(mountpoint string, lazy bool) error {
+	var args []string
+	if lazy {
+		args = append(args, "-f")
+	}
+	output, err := exec.Command("umount", append(args, mountpoint)...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return fmt.Errorf("%s", msg)
+		}
+		return err
+	}
+	return nil
+}
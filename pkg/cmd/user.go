@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// PasswdEntry is an entry of /etc/passwd in the container.
+type PasswdEntry struct {
+	Name string
+	UID  int
+	GID  int
+}
+
+// LookupUser finds the user by the name or the numeric ID from the passwd
+// file in the container.
+func LookupUser(ctx context.Context, e Executor, passwd, user string) (*PasswdEntry, error) {
+	output, err := e.Run(ctx, []string{"cat", passwd})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s on the container: %w", passwd, err)
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(output))
+	for s.Scan() {
+		fields := strings.Split(s.Text(), ":")
+		if len(fields) < 4 {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		gid, err := strconv.Atoi(fields[3])
+		if err != nil {
+			continue
+		}
+		if fields[0] == user || fields[2] == user {
+			return &PasswdEntry{Name: fields[0], UID: uid, GID: gid}, nil
+		}
+	}
+	if s.Err() != nil {
+		return nil, s.Err()
+	}
+	return nil, fmt.Errorf("user %q is not found in %s on the container", user, passwd)
+}
+
+// UserExecutor is an executor to run commands as the user.  Commands are
+// wrapped with setpriv, runuser or su in the container.
+type UserExecutor struct {
+	Executor Executor
+	wrap     func(command []string) []string
+}
+
+// NewUserExecutor detects a command to switch the user in the container, and
+// returns an executor running commands as the user.
+func NewUserExecutor(ctx context.Context, e Executor, user *PasswdEntry) (*UserExecutor, error) {
+	output, err := e.Run(ctx, []string{
+		"sh", "-c", "command -v setpriv || command -v runuser || command -v su",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("none of setpriv, runuser and su is found on the container to run commands as %s", user.Name)
+	}
+
+	ue := &UserExecutor{Executor: e}
+	switch path.Base(strings.TrimSpace(string(output))) {
+	case "setpriv":
+		ue.wrap = func(command []string) []string {
+			return append([]string{
+				"setpriv",
+				"--reuid=" + strconv.Itoa(user.UID),
+				"--regid=" + strconv.Itoa(user.GID),
+				"--init-groups",
+				"--",
+			}, command...)
+		}
+	case "runuser":
+		ue.wrap = func(command []string) []string {
+			return append([]string{"runuser", "-u", user.Name, "--"}, command...)
+		}
+	default:
+		ue.wrap = func(command []string) []string {
+			return []string{"su", "-s", "/bin/sh", user.Name, "-c", shellQuote(command)}
+		}
+	}
+
+	// Confirm that the user can be switched, such as the container runs as
+	// root.
+	output, err = ue.Run(ctx, []string{"id", "-u"})
+	if err != nil {
+		return nil, fmt.Errorf("unable to run commands as %s on the container: %w", user.Name, err)
+	}
+	if strings.TrimSpace(string(output)) != strconv.Itoa(user.UID) {
+		return nil, fmt.Errorf("unable to run commands as %s on the container: uid is %s", user.Name, bytes.TrimSpace(output))
+	}
+	return ue, nil
+}
+
+func (e *UserExecutor) Run(ctx context.Context, command []string) ([]byte, error) {
+	return e.Executor.Run(ctx, e.wrap(command))
+}
+
+func (e *UserExecutor) RunRead(ctx context.Context, command []string) (io.ReadCloser, error) {
+	return e.Executor.RunRead(ctx, e.wrap(command))
+}
+
+func (e *UserExecutor) RunWrite(ctx context.Context, command []string, stdin io.Reader) error {
+	return e.Executor.RunWrite(ctx, e.wrap(command), stdin)
+}
+
+func (e *UserExecutor) RunStream(ctx context.Context, command []string, stdin io.Reader, stdout io.Writer) error {
+	return e.Executor.RunStream(ctx, e.wrap(command), stdin, stdout)
+}
+
+// shellQuote joins the command into a string for sh -c.
+func shellQuote(command []string) string {
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
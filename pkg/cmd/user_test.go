@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestNewUserExecutor(t *testing.T) {
+	user := &PasswdEntry{Name: "nginx", UID: 101, GID: 102}
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{
+			command: "/usr/bin/setpriv",
+			want:    []string{"setpriv", "--reuid=101", "--regid=102", "--init-groups", "--", "id", "-u"},
+		},
+		{
+			command: "/sbin/runuser",
+			want:    []string{"runuser", "-u", "nginx", "--", "id", "-u"},
+		},
+		{
+			command: "/bin/su",
+			want:    []string{"su", "-s", "/bin/sh", "nginx", "-c", "'id' '-u'"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			e := &recordingExecutor{outputs: map[string]string{
+				"sh":       tt.command + "\n",
+				tt.want[0]: "101\n",
+			}}
+			if _, err := NewUserExecutor(context.Background(), e, user); err != nil {
+				t.Fatal(err)
+			}
+			if len(e.commands) != 2 {
+				t.Fatalf("commands = %q, want two commands", e.commands)
+			}
+			if !reflect.DeepEqual(e.commands[1], tt.want) {
+				t.Errorf("command = %q, want %q", e.commands[1], tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// podHandler receives running pods and stopped pods found by watchPods.
+type podHandler struct {
+	Running func(pod *corev1.Pod)
+	Stopped func(pod *corev1.Pod)
+}
+
+// watchPods watches pods matching the label selector and the field selector
+// of the options in the namespace until the context is done.  Pods are passed
+// to the handler when they become running and when they stop running or are
+// deleted.
+func watchPods(ctx context.Context, api kubernetes.Interface, namespace string, selectors metav1.ListOptions, h podHandler) {
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = selectors.LabelSelector
+			options.FieldSelector = selectors.FieldSelector
+			return api.CoreV1().Pods(namespace).List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = selectors.LabelSelector
+			options.FieldSelector = selectors.FieldSelector
+			return api.CoreV1().Pods(namespace).Watch(ctx, options)
+		},
+	}
+	update := func(obj interface{}) {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			return
+		}
+		if pod.DeletionTimestamp == nil && pod.Status.Phase == corev1.PodRunning {
+			h.Running(pod)
+		} else {
+			h.Stopped(pod)
+		}
+	}
+	informer := cache.NewSharedInformer(lw, &corev1.Pod{}, 0)
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    update,
+		UpdateFunc: func(_, obj interface{}) { update(obj) },
+		DeleteFunc: func(obj interface{}) {
+			if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = d.Obj
+			}
+			if pod, ok := obj.(*corev1.Pod); ok {
+				h.Stopped(pod)
+			}
+		},
+	})
+	informer.Run(ctx.Done())
+}
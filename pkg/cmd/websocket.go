@@ -0,0 +1,458 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/websocket"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/remotecommand"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/util/exec"
+)
+
+const (
+	// streamProtocolV5Name is the WebSocket subprotocol of the remote
+	// commands.  It extends v4.channel.k8s.io with the signal to close the
+	// stdin, which the commands writing files require.
+	streamProtocolV5Name = "v5.channel.k8s.io"
+
+	// Channels of the messages in the protocol.  The first byte of each
+	// message is the channel, and the rest is the data.
+	streamStdin  = 0
+	streamStdout = 1
+	streamStderr = 2
+	streamError  = 3
+
+	// streamClose is the channel of the message closing the channel in the
+	// second byte.
+	streamClose = 255
+)
+
+// upgradeError is an error that the server does not upgrade the connection to
+// the WebSocket-based protocol.  The remote commands fall back to SPDY on the
+// error.
+type upgradeError struct {
+	Err error
+}
+
+func (e *upgradeError) Error() string {
+	return fmt.Sprintf("upgrade to %s: %v", streamProtocolV5Name, e.Err)
+}
+
+func (e *upgradeError) Unwrap() error {
+	return e.Err
+}
+
+func isUpgradeError(err error) bool {
+	var upgradeErr *upgradeError
+	return errors.As(err, &upgradeErr)
+}
+
+// webSocketRoundTripper is the innermost round tripper under the wrappers
+// of the config, such as authentication.  It does the WebSocket handshake
+// with the request, and keeps the connection instead of returning the body
+// of the response.  A response other than 101 Switching Protocols is
+// returned as is, so the wrappers see the status of the response.
+type webSocketRoundTripper struct {
+	tlsConfig *tls.Config
+	dial      func(ctx context.Context, network, address string) (net.Conn, error)
+	proxy     func(req *http.Request) (*url.URL, error)
+
+	conn *websocket.Conn
+}
+
+func (rt *webSocketRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	location := *req.URL
+	switch location.Scheme {
+	case "https":
+		location.Scheme = "wss"
+	case "http":
+		location.Scheme = "ws"
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q", location.Scheme)
+	}
+
+	conn, err := rt.dialTarget(req)
+	if err != nil {
+		return nil, err
+	}
+	// The handshake is not aware of the context
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	config := &websocket.Config{
+		Location: &location,
+		Origin:   &url.URL{Scheme: req.URL.Scheme, Host: req.URL.Host},
+		Protocol: []string{streamProtocolV5Name},
+		Version:  websocket.ProtocolVersionHybi13,
+		Header:   req.Header,
+	}
+	hc := &handshakeConn{Conn: conn}
+	ws, err := websocket.NewClient(config, hc)
+	hc.handshaked = true
+	switch {
+	case ctx.Err() != nil:
+		conn.Close()
+		return nil, ctx.Err()
+	case errors.Is(err, websocket.ErrBadStatus):
+		// NewClient discards the response, so it is read again from the
+		// recorded bytes
+		defer conn.Close()
+		resp, err := http.ReadResponse(bufio.NewReader(io.MultiReader(&hc.buf, conn)), req)
+		if err != nil {
+			return nil, err
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxStatusBodySize))
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return resp, nil
+	case errors.Is(err, websocket.ErrBadUpgrade) || errors.Is(err, websocket.ErrBadWebSocketProtocol):
+		conn.Close()
+		return nil, &upgradeError{Err: err}
+	case err != nil:
+		conn.Close()
+		return nil, err
+	}
+	rt.conn = ws
+	return &http.Response{
+		Status:     "101 Switching Protocols",
+		StatusCode: http.StatusSwitchingProtocols,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+		Request:    req,
+	}, nil
+}
+
+// dialTarget connects to the host of the request, through the proxy if the
+// proxy of the request is configured, like the round tripper of SPDY.
+func (rt *webSocketRoundTripper) dialTarget(req *http.Request) (net.Conn, error) {
+	ctx := req.Context()
+	target := canonicalAddr(req.URL)
+	proxyURL, err := rt.proxy(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn
+	if proxyURL == nil {
+		conn, err = rt.dial(ctx, "tcp", target)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		conn, err = rt.dialProxy(ctx, proxyURL, target)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %s: %w", proxyURL.Redacted(), err)
+		}
+	}
+	if req.URL.Scheme != "https" {
+		return conn, nil
+	}
+
+	config := rt.tlsConfig.Clone()
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		config.ServerName = req.URL.Hostname()
+	}
+	// WebSocket is upgraded only from HTTP/1.1
+	config.NextProtos = nil
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// dialProxy opens a tunnel to the target address by CONNECT on the HTTP
+// proxy.
+func (rt *webSocketRoundTripper) dialProxy(ctx context.Context, proxyURL *url.URL, target string) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	switch proxyURL.Scheme {
+	case "http":
+		conn, err = rt.dial(ctx, "tcp", canonicalAddr(proxyURL))
+	case "https":
+		conn, err = rt.dial(ctx, "tcp", canonicalAddr(proxyURL))
+		if err == nil {
+			tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+			if err = tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+			}
+			conn = tlsConn
+		}
+	default:
+		// SPDY may support the proxy
+		return nil, &upgradeError{Err: fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	connectReq := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: http.Header{},
+	}
+	if u := proxyURL.User; u != nil {
+		password, _ := u.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + password))
+		connectReq.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := connectReq.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, connectReq)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected response to CONNECT: %s", resp.Status)
+	}
+	return &bufferedConn{Conn: conn, r: br}, nil
+}
+
+// canonicalAddr returns the host and the port of the URL, with the default
+// port of the scheme.
+func canonicalAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// maxStatusBodySize is the maximum size of the body of the response
+// rejecting the upgrade, which is the status of the error.
+const maxStatusBodySize = 64 * 1024
+
+// handshakeConn is a connection recording the bytes read until the
+// handshake is done.
+type handshakeConn struct {
+	net.Conn
+
+	buf        bytes.Buffer
+	handshaked bool
+}
+
+func (c *handshakeConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if !c.handshaked {
+		c.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+// bufferedConn is a connection of which the bytes already read into the
+// buffered reader are read first.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// dialWebSocket connects to the URL with the WebSocket-based protocol of the
+// remote commands.  The server not supporting the protocol responds with
+// 400 Bad Request or 426 Upgrade Required, which is returned as
+// upgradeError.  The other responses, such as authentication errors, are
+// returned as errors not to fall back to SPDY.
+func dialWebSocket(ctx context.Context, config *restclient.Config, u *url.URL) (*websocket.Conn, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := restclient.TLSConfigFor(config)
+	if err != nil {
+		return nil, err
+	}
+	rt := &webSocketRoundTripper{tlsConfig: tlsConfig, dial: config.Dial, proxy: config.Proxy}
+	if rt.dial == nil {
+		rt.dial = (&net.Dialer{}).DialContext
+	}
+	if rt.proxy == nil {
+		rt.proxy = http.ProxyFromEnvironment
+	}
+	wrapped, err := restclient.HTTPWrappersForConfig(config, rt)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := wrapped.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if rt.conn != nil {
+		resp.Body.Close()
+		return rt.conn, nil
+	}
+
+	err = responseError(resp)
+	if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUpgradeRequired {
+		return nil, &upgradeError{Err: err}
+	}
+	return nil, err
+}
+
+// responseError returns the error of the response rejecting the upgrade,
+// with the message of the status in the body if any, like the executor of
+// SPDY.
+func responseError(resp *http.Response) error {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxStatusBodySize))
+	var status metav1.Status
+	if json.Unmarshal(body, &status) == nil && status.Message != "" {
+		return fmt.Errorf("unable to upgrade connection: %s", status.Message)
+	}
+	if message := strings.TrimSpace(string(body)); message != "" {
+		return fmt.Errorf("unable to upgrade connection: %s: %s", resp.Status, message)
+	}
+	return fmt.Errorf("unable to upgrade connection: %s", resp.Status)
+}
+
+// streamWebSocket runs the remote command of the URL over the WebSocket-based
+// protocol, like Stream of the executor of SPDY.
+func streamWebSocket(ctx context.Context, config *restclient.Config, u *url.URL, stdin io.Reader, stdout, stderr io.Writer) error {
+	ws, err := dialWebSocket(ctx, config, u)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ws.Close()
+		case <-done:
+		}
+	}()
+
+	if stdin != nil {
+		go func() {
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := stdin.Read(buf)
+				if n > 0 {
+					if err := websocket.Message.Send(ws, append([]byte{streamStdin}, buf[:n]...)); err != nil {
+						return
+					}
+				}
+				if err != nil {
+					break
+				}
+			}
+			_ = websocket.Message.Send(ws, []byte{streamClose, streamStdin})
+		}()
+	}
+
+	var status []byte
+	for {
+		var data []byte
+		err := websocket.Message.Receive(ws, &data)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		switch data[0] {
+		case streamStdout:
+			if _, err := stdout.Write(data[1:]); err != nil {
+				return err
+			}
+		case streamStderr:
+			if _, err := stderr.Write(data[1:]); err != nil {
+				return err
+			}
+		case streamError:
+			status = append(status, data[1:]...)
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return decodeStatus(status)
+}
+
+// decodeStatus returns the error of the status in the error channel, which
+// is exec.CodeExitError if the command exits with non-zero code.  The
+// command is considered successful if the server sends no status.
+func decodeStatus(message []byte) error {
+	if len(message) == 0 {
+		return nil
+	}
+	var status metav1.Status
+	if err := json.Unmarshal(message, &status); err != nil {
+		return fmt.Errorf("error stream protocol error: %v in %q", err, message)
+	}
+	switch status.Status {
+	case metav1.StatusSuccess:
+		return nil
+	case metav1.StatusFailure:
+		if status.Reason != remotecommand.NonZeroExitCodeReason {
+			return errors.New(status.Message)
+		}
+		if status.Details != nil {
+			for _, c := range status.Details.Causes {
+				if c.Type != remotecommand.ExitCodeCauseType {
+					continue
+				}
+				code, err := strconv.ParseUint(c.Message, 10, 8)
+				if err != nil {
+					return fmt.Errorf("error stream protocol error: invalid exit code value %q", c.Message)
+				}
+				return exec.CodeExitError{
+					Err:  fmt.Errorf("command terminated with exit code %d", code),
+					Code: int(code),
+				}
+			}
+		}
+		return fmt.Errorf("error stream protocol error: no %s cause given", remotecommand.ExitCodeCauseType)
+	default:
+		return errors.New("error stream protocol error: unknown error")
+	}
+}
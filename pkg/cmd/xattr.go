@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+
+	fusefs "github.com/hanwen/go-fuse/v2/fs"
+)
+
+// XattrFS is the interface implemented by a file system which can read
+// extended attributes of files on it.
+type XattrFS interface {
+	fs.FS
+
+	// Xattrs returns extended attributes of the file.  It returns
+	// syscall.ENOTSUP if the file system does not support them.
+	Xattrs(name string) (map[string][]byte, error)
+}
+
+// Xattrs returns extended attributes of the file on the file system, or
+// syscall.ENOTSUP if fsys does not implement XattrFS.
+func Xattrs(fsys fs.FS, name string) (map[string][]byte, error) {
+	if fsys, ok := fsys.(XattrFS); ok {
+		return fsys.Xattrs(name)
+	}
+	return nil, syscall.ENOTSUP
+}
+
+// Xattrs returns extended attributes of the file by getfattr in the
+// container.  It returns syscall.ENOTSUP if getfattr is not available.
+// Symlinks are not followed like stat.
+func (f *PodFS) Xattrs(name string) (map[string][]byte, error) {
+	if !f.capabilities().Has("getfattr") {
+		return nil, syscall.ENOTSUP
+	}
+	output, err := f.Executor.Run(context.TODO(), []string{
+		"getfattr", "--absolute-names", "-h", "-d", "-m", "-", "-e", "hex",
+		"--", path.Join(f.Pwd, name),
+	})
+	if err != nil {
+		return nil, toOSError(err)
+	}
+	return parseGetfattr(output)
+}
+
+// parseGetfattr parses the output of getfattr -d -e hex, which consists of
+// name=0xvalue lines following a "# file:" comment.  A name without a value
+// is an empty attribute.
+func parseGetfattr(output []byte) (map[string][]byte, error) {
+	attrs := map[string][]byte{}
+	s := bufio.NewScanner(bytes.NewReader(output))
+	for s.Scan() {
+		line := s.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value := line, ""
+		if i := strings.IndexByte(line, '='); i >= 0 {
+			name, value = line[:i], line[i+1:]
+		}
+		data, err := hex.DecodeString(strings.TrimPrefix(value, "0x"))
+		if err != nil {
+			return nil, err
+		}
+		attrs[name] = data
+	}
+	return attrs, s.Err()
+}
+
+// getXattrs returns extended attributes of the file, including the user.k8s.*
+// attributes of the pod and the container.  Remote attributes are read only
+// with --xattrs, since listing them needs a remote call for each file.
+func (n *PodFuseNode) getXattrs() (map[string][]byte, syscall.Errno) {
+	attrs := map[string][]byte{}
+	if n.xattrs {
+		remote, err := Xattrs(n.fsys, n.file)
+		if err != nil && !errors.Is(err, syscall.ENOTSUP) {
+			return nil, fusefs.ToErrno(err)
+		}
+		for name, value := range remote {
+			attrs[name] = value
+		}
+	}
+	if n.source != nil {
+		if pod, containerName := n.source(); pod != nil {
+			attrs["user.k8s.pod"] = []byte(pod.Name)
+			attrs["user.k8s.namespace"] = []byte(pod.Namespace)
+			attrs["user.k8s.container"] = []byte(containerName)
+			attrs["user.k8s.node"] = []byte(pod.Spec.NodeName)
+		}
+	}
+	return attrs, fusefs.OK
+}
+
+func (n *PodFuseNode) Getxattr(ctx context.Context, attr string, dest []byte) (uint32, syscall.Errno) {
+	attrs, errno := n.getXattrs()
+	if errno != fusefs.OK {
+		return 0, errno
+	}
+	value, ok := attrs[attr]
+	if !ok {
+		return 0, syscall.ENODATA
+	}
+	if len(dest) < len(value) {
+		return uint32(len(value)), syscall.ERANGE
+	}
+	return uint32(copy(dest, value)), fusefs.OK
+}
+
+func (n *PodFuseNode) Listxattr(ctx context.Context, dest []byte) (uint32, syscall.Errno) {
+	attrs, errno := n.getXattrs()
+	if errno != fusefs.OK {
+		return 0, errno
+	}
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var list []byte
+	for _, name := range names {
+		list = append(list, name...)
+		list = append(list, 0)
+	}
+	if len(dest) < len(list) {
+		return uint32(len(list)), syscall.ERANGE
+	}
+	return uint32(copy(dest, list)), fusefs.OK
+}